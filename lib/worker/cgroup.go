@@ -0,0 +1,196 @@
+//go:build linux
+
+package worker
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// DefaultRootCgroup is the cgroup v2 directory used when Config.RootCgroup
+// is empty. It is expected to already be delegated to this process, e.g.
+// via systemd's Delegate=yes on the enclosing unit.
+const DefaultRootCgroup = "/sys/fs/cgroup/worker"
+
+// cgroupManager creates and tears down the per-process child cgroups
+// under a single delegated parent cgroup.
+type cgroupManager struct {
+	root string
+}
+
+// newCgroupManager verifies (and creates, if missing) the delegated parent
+// cgroup at root. It does not fail construction if cgroups are unavailable;
+// errors are instead surfaced lazily from newChild, since a Worker that
+// never requests ResourceLimits has no need for a working cgroup tree.
+func newCgroupManager(root string) *cgroupManager {
+	if root == "" {
+		root = DefaultRootCgroup
+	}
+	return &cgroupManager{root: root}
+}
+
+// newChild creates a child cgroup for id under the manager's root,
+// verifying the parent exists (creating it on first use) first.
+func (m *cgroupManager) newChild(id ID) (*cgroup, error) {
+	if err := os.MkdirAll(m.root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create delegated parent cgroup %s: %w", m.root, err)
+	}
+
+	path := filepath.Join(m.root, string(id))
+	if err := os.Mkdir(path, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cgroup %s: %w", path, err)
+	}
+	return &cgroup{path: path}, nil
+}
+
+// cgroup represents a single child cgroup created for a process.
+type cgroup struct {
+	path string
+}
+
+// apply writes limits to the relevant cgroup v2 interface files. Fields
+// left at their zero value are not written, leaving the kernel default
+// (unlimited) in place.
+func (c *cgroup) apply(limits ResourceLimits) error {
+	if limits.MaxMemoryBytes != 0 {
+		if err := c.writeFile("memory.max", strconv.FormatInt(limits.MaxMemoryBytes, 10)); err != nil {
+			return err
+		}
+	}
+
+	if limits.CPUMax != (CPUMax{}) {
+		quota := "max"
+		if limits.CPUMax.QuotaMicros > 0 {
+			quota = strconv.FormatInt(limits.CPUMax.QuotaMicros, 10)
+		}
+		period := limits.CPUMax.PeriodMicros
+		if period == 0 {
+			period = 100000 // cgroup v2 default period, 100ms
+		}
+		if err := c.writeFile("cpu.max", fmt.Sprintf("%s %d", quota, period)); err != nil {
+			return err
+		}
+	}
+
+	if limits.CPUWeight != 0 {
+		if err := c.writeFile("cpu.weight", strconv.FormatUint(limits.CPUWeight, 10)); err != nil {
+			return err
+		}
+	}
+
+	for _, io := range limits.IOMax {
+		line := fmt.Sprintf("%d:%d rbps=%s wbps=%s riops=%s wiops=%s",
+			io.Major, io.Minor, nonZeroOrMax(io.RBPS), nonZeroOrMax(io.WBPS), nonZeroOrMax(io.RIOPS), nonZeroOrMax(io.WIOPS))
+		if err := c.writeFile("io.max", line); err != nil {
+			return err
+		}
+	}
+
+	if limits.PidsMax != 0 {
+		if err := c.writeFile("pids.max", strconv.FormatInt(limits.PidsMax, 10)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// open returns an *os.File for the cgroup directory, for use with
+// syscall.SysProcAttr's UseCgroupFD/CgroupFD so a child can be cloned
+// directly into the cgroup via CLONE_INTO_CGROUP (see applyCgroupFD).
+// The caller is responsible for closing it once the process has started.
+func (c *cgroup) open() (*os.File, error) {
+	f, err := os.Open(c.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cgroup %s: %w", c.path, err)
+	}
+	return f, nil
+}
+
+// applyCgroupFD configures cmd so the kernel clones it directly into
+// cg via CLONE_INTO_CGROUP (Linux >= 5.7), closing the window a
+// separately-issued cgroup.procs write would otherwise leave, during
+// which the exec'd binary runs unconstrained by cg's limits. It returns
+// the opened cgroup directory, which the caller must keep open until
+// cmd.Start (or StartPTY) returns, then close.
+func applyCgroupFD(cmd *exec.Cmd, cg *cgroup) (*os.File, error) {
+	dir, err := cg.open()
+	if err != nil {
+		return nil, err
+	}
+
+	attr := cmd.SysProcAttr
+	if attr == nil {
+		attr = &syscall.SysProcAttr{}
+		cmd.SysProcAttr = attr
+	}
+	attr.UseCgroupFD = true
+	attr.CgroupFD = int(dir.Fd())
+
+	return dir, nil
+}
+
+// oomKilled reports whether the kernel has recorded at least one OOM
+// kill against this cgroup, by reading the oom_kill counter out of
+// memory.events. Once remove has deleted the cgroup directory (which
+// GetProcessStatus can observe, e.g. after a StopProcess/
+// StopProcessGraceful that already ran to completion), memory.events
+// is gone too; that is reported as (false, nil) rather than an error,
+// since by then the process is no longer running under the cgroup and
+// its final OOM state, if any, was whatever the last successful read
+// saw.
+func (c *cgroup) oomKilled() (bool, error) {
+	f, err := os.Open(filepath.Join(c.path, "memory.events"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || fields[0] != "oom_kill" {
+			continue
+		}
+		count, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return false, fmt.Errorf("malformed memory.events oom_kill value: %w", err)
+		}
+		return count > 0, nil
+	}
+	return false, scanner.Err()
+}
+
+// remove deletes the cgroup directory. The cgroup must be empty of
+// processes, which is true once the process it was created for has
+// exited.
+func (c *cgroup) remove() error {
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove cgroup %s: %w", c.path, err)
+	}
+	return nil
+}
+
+func (c *cgroup) writeFile(name, value string) error {
+	path := filepath.Join(c.path, name)
+	if err := os.WriteFile(path, []byte(value), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+func nonZeroOrMax(v int64) string {
+	if v == 0 {
+		return "max"
+	}
+	return strconv.FormatInt(v, 10)
+}
\ No newline at end of file
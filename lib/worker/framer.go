@@ -0,0 +1,172 @@
+package worker
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// DefaultChunkBytes is the frame size used by FixedSizeFramer when no
+// other Framer is configured, preserving the handler's original
+// behaviour of forwarding output in fixed-size chunks.
+const DefaultChunkBytes = 76
+
+// Framer controls how an OutputHandler splits a reader's byte stream
+// into the discrete frames forwarded to listeners. Different Framers
+// suit different consumers: FixedSizeFramer is simplest but can split a
+// line or UTF-8 rune across two frames; LineFramer keeps whole log
+// lines together; LengthPrefixedFramer is for consumers that need exact
+// record boundaries, e.g. binary protocols.
+type Framer interface {
+	// NewFrameReader wraps r to produce frames from it per this
+	// Framer's policy.
+	NewFrameReader(r io.Reader) FrameReader
+}
+
+// FrameReader yields the frames of a single underlying reader.
+type FrameReader interface {
+	// ReadFrame returns the next frame. It returns io.EOF (wrapped or
+	// not) once the underlying reader is exhausted.
+	ReadFrame() ([]byte, error)
+}
+
+// FixedSizeFramer splits output into chunks of a fixed byte size,
+// regardless of line or record boundaries. This is the handler's
+// original behaviour.
+type FixedSizeFramer struct {
+	// Size is the chunk size in bytes. Zero uses DefaultChunkBytes.
+	Size int
+}
+
+// NewFrameReader implements Framer.
+func (f FixedSizeFramer) NewFrameReader(r io.Reader) FrameReader {
+	size := f.Size
+	if size <= 0 {
+		size = DefaultChunkBytes
+	}
+	return &fixedSizeFrameReader{r: r, size: size}
+}
+
+type fixedSizeFrameReader struct {
+	r    io.Reader
+	size int
+}
+
+func (f *fixedSizeFrameReader) ReadFrame() ([]byte, error) {
+	buf := make([]byte, f.size)
+	n, err := io.ReadFull(f.r, buf)
+	buf = buf[:n]
+	if err != nil && err != io.ErrUnexpectedEOF {
+		if n == 0 {
+			return nil, err
+		}
+		return buf, nil
+	}
+	return buf, nil
+}
+
+// LineFramer splits output on newlines, so a frame is always a whole
+// line (minus its trailing '\n'). Lines longer than MaxLineBytes are
+// cut at that length instead of being buffered without bound.
+type LineFramer struct {
+	// MaxLineBytes caps how much of a single line is buffered before it
+	// is forwarded anyway. Zero uses DefaultMaxLineBytes.
+	MaxLineBytes int
+}
+
+// DefaultMaxLineBytes is the cap used by LineFramer when MaxLineBytes is
+// left unset.
+const DefaultMaxLineBytes = 1 << 16 // 64 KiB
+
+// NewFrameReader implements Framer.
+func (f LineFramer) NewFrameReader(r io.Reader) FrameReader {
+	max := f.MaxLineBytes
+	if max <= 0 {
+		max = DefaultMaxLineBytes
+	}
+	// bufio.Reader only reports ErrBufferFull, which is what drives the
+	// max-length cut below, once its own internal buffer fills without
+	// finding '\n'. Sizing it to max (rather than bufio.NewReader's
+	// default 4096) is what makes that cut actually happen at max,
+	// instead of at whatever the default buffer size happens to be.
+	return &lineFrameReader{br: bufio.NewReaderSize(r, max), max: max}
+}
+
+type lineFrameReader struct {
+	br  *bufio.Reader
+	max int
+}
+
+func (f *lineFrameReader) ReadFrame() ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := f.br.ReadSlice('\n')
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull && len(line) < f.max {
+			continue
+		}
+		if err != nil && err != io.EOF {
+			if len(line) > 0 {
+				return trimNewline(line), nil
+			}
+			return nil, err
+		}
+		if len(line) == 0 {
+			return nil, io.EOF
+		}
+		return trimNewline(line), nil
+	}
+}
+
+func trimNewline(line []byte) []byte {
+	if n := len(line); n > 0 && line[n-1] == '\n' {
+		return line[:n-1]
+	}
+	return line
+}
+
+// LengthPrefixedFramer reads frames as a 4-byte big-endian length
+// prefix followed by that many bytes of payload, for binary consumers
+// that need exact record boundaries rather than a raw byte stream.
+type LengthPrefixedFramer struct {
+	// MaxFrameBytes caps the payload size accepted from a single length
+	// prefix. Zero uses DefaultMaxFrameBytes. Without a cap, a
+	// corrupted or adversarial 4-byte prefix could otherwise force an
+	// allocation of up to 4 GiB per frame.
+	MaxFrameBytes uint32
+}
+
+// DefaultMaxFrameBytes is the cap used by LengthPrefixedFramer when
+// MaxFrameBytes is left unset.
+const DefaultMaxFrameBytes = 16 << 20 // 16 MiB
+
+// NewFrameReader implements Framer.
+func (f LengthPrefixedFramer) NewFrameReader(r io.Reader) FrameReader {
+	max := f.MaxFrameBytes
+	if max == 0 {
+		max = DefaultMaxFrameBytes
+	}
+	return &lengthPrefixedFrameReader{r: r, max: max}
+}
+
+type lengthPrefixedFrameReader struct {
+	r   io.Reader
+	max uint32
+}
+
+func (f *lengthPrefixedFrameReader) ReadFrame() ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(f.r, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > f.max {
+		return nil, fmt.Errorf("length-prefixed frame of %d bytes exceeds max of %d", size, f.max)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(f.r, payload); err != nil {
+		return nil, fmt.Errorf("truncated length-prefixed frame: %w", err)
+	}
+	return payload, nil
+}
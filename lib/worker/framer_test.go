@@ -0,0 +1,188 @@
+package worker_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trivelle/worker/lib/worker"
+)
+
+// readAllFrames drains fr until it returns io.EOF, failing the test on
+// any other error.
+func readAllFrames(t *testing.T, fr worker.FrameReader) [][]byte {
+	t.Helper()
+
+	var frames [][]byte
+	for {
+		frame, err := fr.ReadFrame()
+		if err == io.EOF {
+			return frames
+		}
+		require.NoError(t, err)
+		// ReadFrame's returned slice may share a reader-owned buffer
+		// that gets reused on the next call; copy it so the assertions
+		// below see a stable snapshot.
+		frames = append(frames, append([]byte(nil), frame...))
+	}
+}
+
+func TestLineFramerSplitsOnNewlines(t *testing.T) {
+	fr := (worker.LineFramer{}).NewFrameReader(strings.NewReader("one\ntwo\nthree"))
+	frames := readAllFrames(t, fr)
+
+	var got []string
+	for _, f := range frames {
+		got = append(got, string(f))
+	}
+	assert.Equal(t, []string{"one", "two", "three"}, got)
+}
+
+func TestLineFramerTruncatesLinesLongerThanMax(t *testing.T) {
+	// bufio.NewReaderSize enforces a 16-byte minimum buffer, which is
+	// also the smallest MaxLineBytes that can actually cut a line
+	// short; a 32-byte line makes the first cut land mid-line.
+	fr := (worker.LineFramer{MaxLineBytes: 16}).NewFrameReader(strings.NewReader("0123456789abcdef0123456789abcdef\nshort\n"))
+	frames := readAllFrames(t, fr)
+
+	var got []string
+	for _, f := range frames {
+		got = append(got, string(f))
+	}
+	// The truncated line's own trailing '\n', never consumed by the
+	// chunk that hit the cut, surfaces as an empty frame of its own
+	// before the next real line.
+	assert.Equal(t, []string{"0123456789abcdef", "0123456789abcdef", "", "short"}, got)
+}
+
+func TestLineFramerHandlesUTF8Lines(t *testing.T) {
+	fr := (worker.LineFramer{}).NewFrameReader(strings.NewReader("héllo\n世界\n"))
+	frames := readAllFrames(t, fr)
+
+	require.Len(t, frames, 2)
+	assert.Equal(t, "héllo", string(frames[0]))
+	assert.Equal(t, "世界", string(frames[1]))
+}
+
+func TestLineFramerReassemblesPartialReads(t *testing.T) {
+	// iotest.OneByteReader forces the underlying bufio.Reader to fill
+	// its buffer one byte at a time, exercising ReadFrame's loop over
+	// multiple short reads rather than a single ReadSlice call.
+	r := iotest.OneByteReader(strings.NewReader("partial\nreads\n"))
+	fr := (worker.LineFramer{}).NewFrameReader(r)
+	frames := readAllFrames(t, fr)
+
+	var got []string
+	for _, f := range frames {
+		got = append(got, string(f))
+	}
+	assert.Equal(t, []string{"partial", "reads"}, got)
+}
+
+func TestFixedSizeFramerSplitsIntoEqualChunks(t *testing.T) {
+	fr := (worker.FixedSizeFramer{Size: 4}).NewFrameReader(strings.NewReader("aaaabbbbcc"))
+	frames := readAllFrames(t, fr)
+
+	var got []string
+	for _, f := range frames {
+		got = append(got, string(f))
+	}
+	assert.Equal(t, []string{"aaaa", "bbbb", "cc"}, got)
+}
+
+func TestFixedSizeFramerUsesDefaultChunkBytesWhenUnset(t *testing.T) {
+	data := strings.Repeat("x", worker.DefaultChunkBytes+1)
+	fr := (worker.FixedSizeFramer{}).NewFrameReader(strings.NewReader(data))
+	frames := readAllFrames(t, fr)
+
+	require.Len(t, frames, 2)
+	assert.Len(t, frames[0], worker.DefaultChunkBytes)
+	assert.Len(t, frames[1], 1)
+}
+
+func TestFixedSizeFramerReassemblesPartialReads(t *testing.T) {
+	r := iotest.OneByteReader(strings.NewReader("aaaabbbbcc"))
+	fr := (worker.FixedSizeFramer{Size: 4}).NewFrameReader(r)
+	frames := readAllFrames(t, fr)
+
+	var got []string
+	for _, f := range frames {
+		got = append(got, string(f))
+	}
+	assert.Equal(t, []string{"aaaa", "bbbb", "cc"}, got)
+}
+
+// lengthPrefixedFrame encodes payload with the 4-byte big-endian length
+// prefix LengthPrefixedFramer expects.
+func lengthPrefixedFrame(payload []byte) []byte {
+	var buf bytes.Buffer
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(payload)))
+	buf.Write(header[:])
+	buf.Write(payload)
+	return buf.Bytes()
+}
+
+func TestLengthPrefixedFramerReadsExactRecordBoundaries(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write(lengthPrefixedFrame([]byte("hello")))
+	buf.Write(lengthPrefixedFrame([]byte("世界")))
+	buf.Write(lengthPrefixedFrame(nil))
+
+	fr := (worker.LengthPrefixedFramer{}).NewFrameReader(&buf)
+	frames := readAllFrames(t, fr)
+
+	require.Len(t, frames, 3)
+	assert.Equal(t, "hello", string(frames[0]))
+	assert.Equal(t, "世界", string(frames[1]))
+	assert.Equal(t, "", string(frames[2]))
+}
+
+func TestLengthPrefixedFramerReassemblesPartialReads(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write(lengthPrefixedFrame([]byte("hello")))
+	buf.Write(lengthPrefixedFrame([]byte("world")))
+
+	fr := (worker.LengthPrefixedFramer{}).NewFrameReader(iotest.OneByteReader(&buf))
+	frames := readAllFrames(t, fr)
+
+	require.Len(t, frames, 2)
+	assert.Equal(t, "hello", string(frames[0]))
+	assert.Equal(t, "world", string(frames[1]))
+}
+
+func TestLengthPrefixedFramerErrorsOnTruncatedPayload(t *testing.T) {
+	full := lengthPrefixedFrame([]byte("hello"))
+	truncated := full[:len(full)-2]
+
+	fr := (worker.LengthPrefixedFramer{}).NewFrameReader(bytes.NewReader(truncated))
+	_, err := fr.ReadFrame()
+	assert.Error(t, err)
+}
+
+func TestLengthPrefixedFramerRejectsOversizedLengthPrefix(t *testing.T) {
+	var header [4]byte
+	// A length prefix claiming a multi-GB payload, with no data backing
+	// it: without a cap this would make ReadFrame attempt a multi-GB
+	// allocation before ever touching the (absent) payload bytes.
+	binary.BigEndian.PutUint32(header[:], 0xFFFFFFF0)
+
+	fr := (worker.LengthPrefixedFramer{MaxFrameBytes: 1024}).NewFrameReader(bytes.NewReader(header[:]))
+	_, err := fr.ReadFrame()
+	assert.Error(t, err)
+}
+
+func TestLengthPrefixedFramerDefaultMaxFrameBytesRejectsOversizedPrefix(t *testing.T) {
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], worker.DefaultMaxFrameBytes+1)
+
+	fr := (worker.LengthPrefixedFramer{}).NewFrameReader(bytes.NewReader(header[:]))
+	_, err := fr.ReadFrame()
+	assert.Error(t, err)
+}
@@ -0,0 +1,74 @@
+package worker
+
+// IsolationConfig configures the Linux namespaces, chroot and
+// credentials a ProcessRequest's process is started with. The zero
+// value requests no isolation at all, matching the worker's behaviour
+// before IsolationConfig existed.
+type IsolationConfig struct {
+	// Namespaces selects which new namespaces the process is started
+	// in, equivalent to clone(2)'s CLONE_NEWxxx flags.
+	Namespaces NamespaceFlags
+
+	// Chroot, if set, is the directory the process is chrooted into
+	// before exec.
+	Chroot string
+
+	// Hostname sets the process's hostname inside its own UTS
+	// namespace. Ignored unless Namespaces.UTS is set.
+	Hostname string
+
+	// Credential sets the UID/GID the process execs as.
+	Credential *Credential
+
+	// UIDMappings and GIDMappings populate /proc/[pid]/uid_map and
+	// gid_map for a new user namespace. Ignored unless Namespaces.User
+	// is set.
+	UIDMappings []IDMap
+	GIDMappings []IDMap
+
+	// CreatePseudoDevices creates /dev/null, /dev/zero, /dev/random,
+	// /dev/urandom, /dev/tty and /dev/full under Chroot before exec, so
+	// a process chrooted into an otherwise empty directory still has a
+	// usable /dev. Ignored unless Chroot is set.
+	CreatePseudoDevices bool
+}
+
+// NamespaceFlags selects which Linux namespaces a process is started
+// in. Each field is independent; e.g. Mount without PID puts the
+// process in a new mount namespace while still sharing the worker's
+// PID namespace.
+type NamespaceFlags struct {
+	PID     bool
+	Mount   bool
+	UTS     bool
+	IPC     bool
+	Network bool
+	User    bool
+}
+
+func (n NamespaceFlags) isZero() bool {
+	return !n.PID && !n.Mount && !n.UTS && !n.IPC && !n.Network && !n.User
+}
+
+// Credential is the UID/GID a process execs as.
+type Credential struct {
+	UID uint32
+	GID uint32
+}
+
+// IDMap is a single line of /proc/[pid]/uid_map or gid_map: Size
+// contiguous IDs starting at ContainerID inside the new namespace map
+// to Size contiguous IDs starting at HostID outside it.
+type IDMap struct {
+	ContainerID uint32
+	HostID      uint32
+	Size        uint32
+}
+
+// isZero reports whether cfg requests no isolation at all, in which
+// case the worker can start the process exactly as it did before
+// IsolationConfig existed.
+func (cfg IsolationConfig) isZero() bool {
+	return cfg.Namespaces.isZero() && cfg.Chroot == "" && cfg.Credential == nil &&
+		len(cfg.UIDMappings) == 0 && len(cfg.GIDMappings) == 0 && !cfg.CreatePseudoDevices
+}
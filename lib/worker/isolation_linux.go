@@ -0,0 +1,157 @@
+//go:build linux
+
+package worker
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+// applyIsolation configures cmd to start in the namespaces, chroot and
+// credentials requested by cfg. It must be called before cmd.Start (or
+// pty.StartWithSize).
+//
+// UID/GID mappings for a new user namespace are set via
+// syscall.SysProcAttr's UidMappings/GidMappings rather than writing
+// /proc/[pid]/uid_map and gid_map by hand: the Go runtime already
+// synchronises this correctly with the child over an internal pipe,
+// writing the mappings from the parent after clone(2) but before the
+// child execs, which is exactly the ordering a hand-rolled pipe would
+// be built to guarantee.
+func applyIsolation(cmd *exec.Cmd, cfg IsolationConfig) error {
+	if cfg.isZero() {
+		return nil
+	}
+
+	attr := cmd.SysProcAttr
+	if attr == nil {
+		attr = &syscall.SysProcAttr{}
+		cmd.SysProcAttr = attr
+	}
+
+	if cfg.Namespaces.PID {
+		attr.Cloneflags |= syscall.CLONE_NEWPID
+	}
+	if cfg.Namespaces.Mount {
+		attr.Cloneflags |= syscall.CLONE_NEWNS
+	}
+	if cfg.Namespaces.UTS {
+		attr.Cloneflags |= syscall.CLONE_NEWUTS
+	}
+	if cfg.Namespaces.IPC {
+		attr.Cloneflags |= syscall.CLONE_NEWIPC
+	}
+	if cfg.Namespaces.Network {
+		attr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+	if cfg.Namespaces.User {
+		attr.Cloneflags |= syscall.CLONE_NEWUSER
+		attr.UidMappings = toSysProcIDMap(cfg.UIDMappings)
+		attr.GidMappings = toSysProcIDMap(cfg.GIDMappings)
+	}
+	if cfg.Credential != nil {
+		attr.Credential = &syscall.Credential{Uid: cfg.Credential.UID, Gid: cfg.Credential.GID}
+	}
+
+	if cfg.Chroot != "" {
+		if cfg.CreatePseudoDevices {
+			if err := createPseudoDevices(cfg.Chroot); err != nil {
+				return fmt.Errorf("failed to create pseudo-devices in chroot: %w", err)
+			}
+		}
+		attr.Chroot = cfg.Chroot
+		// os/exec only chdir()s the child if cmd.Dir is set; without
+		// this, the child keeps its pre-chroot working directory and
+		// can still resolve relative paths outside the jail.
+		cmd.Dir = "/"
+	}
+
+	// Setting the hostname has to happen inside the new UTS namespace,
+	// i.e. after clone(2) but before exec. os/exec has no hook for
+	// running parent-supplied code at that point, so the command is
+	// wrapped in a shell that calls hostname(1) first.
+	// TODO: this requires /bin/sh (and the hostname(1) binary) to be
+	// resolvable at exec time, which may not hold once Chroot is also
+	// set; for a chroot with no shell, Hostname cannot currently be
+	// applied.
+	if cfg.Hostname != "" && cfg.Namespaces.UTS {
+		wrapWithHostname(cmd, cfg.Hostname)
+	}
+
+	return nil
+}
+
+func toSysProcIDMap(mappings []IDMap) []syscall.SysProcIDMap {
+	out := make([]syscall.SysProcIDMap, len(mappings))
+	for i, m := range mappings {
+		out[i] = syscall.SysProcIDMap{
+			ContainerID: int(m.ContainerID),
+			HostID:      int(m.HostID),
+			Size:        int(m.Size),
+		}
+	}
+	return out
+}
+
+// wrapWithHostname rewrites cmd to run the original command under a
+// shell that sets hostname first, so it takes effect inside the new
+// UTS namespace before the real binary execs.
+func wrapWithHostname(cmd *exec.Cmd, hostname string) {
+	exePath := cmd.Path
+	args := cmd.Args
+
+	cmd.Path = "/bin/sh"
+	cmd.Args = append([]string{"sh", "-c", `hostname "$ISOLATION_HOSTNAME" && exec "$ISOLATION_EXE" "$@"`, "sh"}, args[1:]...)
+	// cmd.Env is nil in the common case (ProcessRequest has no Env
+	// field to populate it), and a nil cmd.Env means "use the current
+	// process's environment" - appending to it directly would instead
+	// replace the child's entire environment with just these two
+	// variables. Seed from os.Environ() first (or keep cmd.Env as-is
+	// if the caller already set one) so the child still inherits
+	// PATH, HOME, etc.
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = append(env, "ISOLATION_HOSTNAME="+hostname, "ISOLATION_EXE="+exePath)
+}
+
+type pseudoDevice struct {
+	name         string
+	major, minor uint32
+	mode         uint32
+}
+
+// pseudoDevices are the standard /dev character devices most programs
+// expect to exist, even inside an otherwise-empty chroot.
+var pseudoDevices = []pseudoDevice{
+	{"null", 1, 3, 0666},
+	{"zero", 1, 5, 0666},
+	{"full", 1, 7, 0666},
+	{"random", 1, 8, 0666},
+	{"urandom", 1, 9, 0666},
+	{"tty", 5, 0, 0666},
+}
+
+// createPseudoDevices creates the devices in pseudoDevices under
+// <chroot>/dev. Requires CAP_MKNOD (typically: running as root).
+func createPseudoDevices(chroot string) error {
+	devDir := filepath.Join(chroot, "dev")
+	if err := os.MkdirAll(devDir, 0755); err != nil {
+		return err
+	}
+	for _, d := range pseudoDevices {
+		path := filepath.Join(devDir, d.name)
+		// This major<<8|minor encoding only covers the low major/minor
+		// numbers used by these standard devices; it is not the general
+		// glibc makedev(3) formula, which also packs bits above 1<<8.
+		dev := int((d.major << 8) | d.minor)
+		if err := syscall.Mknod(path, syscall.S_IFCHR|d.mode, dev); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("failed to create %s: %w", path, err)
+		}
+	}
+	return nil
+}
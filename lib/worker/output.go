@@ -1,48 +1,141 @@
 package worker
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"os"
 	"sync"
 	"time"
 )
 
+// DefaultRingBufferBytes is the in-memory tail size used when
+// OutputHandlerConfig.RingBufferBytes is left unset.
+const DefaultRingBufferBytes = 1 << 20 // 1 MiB
+
+// DefaultListenerQueueDepth is the per-listener queue depth used when
+// OutputHandlerConfig.ListenerQueueDepth is left unset.
+const DefaultListenerQueueDepth = 256
+
+// messageQueueDepth is the buffer depth of the OutputHandler's internal
+// messages channel, decoupling readers from the broadcast goroutine.
+const messageQueueDepth = 64
+
+// ErrSlowConsumer is sent on a listener's error channel, and the
+// listener's output channel is closed, when it falls behind the
+// configured ListenerQueueDepth and is disconnected so that it cannot
+// stall delivery to other listeners.
+var ErrSlowConsumer = errors.New("listener did not keep up with output and was disconnected")
+
+// Stream identifies which of a process's output streams a
+// ProcessOutputEntry came from.
+type Stream int
+
+const (
+	// Stdout is the process's standard output, or its PTY when one was
+	// allocated (stdout and stderr are not distinguishable over a PTY).
+	Stdout Stream = iota
+	// Stderr is the process's standard error.
+	Stderr
+)
+
+func (s Stream) String() string {
+	if s == Stderr {
+		return "stderr"
+	}
+	return "stdout"
+}
+
+// RetentionPolicy controls how long a process's output, once spilled to
+// disk, is kept around.
+type RetentionPolicy struct {
+	// MaxBytes caps the spool file at the given size, dropping the
+	// oldest bytes first. Zero means unbounded.
+	MaxBytes int64
+
+	// MaxAge is how long spilled output is kept before it is eligible
+	// for cleanup.
+	// TODO: not yet enforced; the spool file is currently only trimmed
+	// by MaxBytes and removed wholesale on Close.
+	MaxAge time.Duration
+
+	// KeepUntilDelete disables both MaxBytes and MaxAge trimming; the
+	// full spool file is kept until the OutputHandler is closed.
+	KeepUntilDelete bool
+}
+
+// OutputHandlerConfig configures how an OutputHandler buffers output.
+type OutputHandlerConfig struct {
+	// RingBufferBytes bounds the in-memory tail kept for replaying to
+	// new listeners. Output older than this is spilled to disk. Zero
+	// uses DefaultRingBufferBytes.
+	RingBufferBytes int
+
+	// SpoolDir is the directory spilled output is written to. Empty
+	// uses os.TempDir().
+	SpoolDir string
+
+	// Retention controls how long spilled output survives.
+	Retention RetentionPolicy
+
+	// ListenerQueueDepth bounds how many messages a listener can fall
+	// behind before it is disconnected with ErrSlowConsumer, so that one
+	// slow consumer cannot stall delivery to the rest. Zero uses
+	// DefaultListenerQueueDepth.
+	ListenerQueueDepth int
+
+	// Framer controls how each reader's byte stream is split into the
+	// frames forwarded to listeners. Nil uses FixedSizeFramer{}, i.e.
+	// fixed DefaultChunkBytes-sized chunks.
+	Framer Framer
+}
+
 // OutputHandler manages output buffering and forwarding to
 // concurrent output listeners.
 //
 // It is initialised with one or more readers which it
 // reads to buffer the content and send to listeners
 //
-// OutputHandler attempts to forward output by chunks of
-// length 76.
-// TODO: the chunk size should be configurable
+// How output is split into discrete frames is controlled by
+// OutputHandlerConfig.Framer; the default, FixedSizeFramer, preserves
+// the handler's original behaviour of forwarding fixed 76-byte chunks.
 //
 // Forwarded content for a new listener is always sent from
 // the start. Updates are always sent in the order they were
 // read. This means output is always in order by reader but
-// not necessarily across readers.
+// not necessarily across readers; each entry's Stream field records
+// which reader it came from so a consumer can recover per-stream order.
 //
-// TODO: This could possibly be an interface so that the way
-// we forward output is configurable. For example, we might
-// have an output handler that sends output based on time
-// ellapsed.
-//
-// TODO: At the moment, all OutputHandlers are kept in memory.
-// This could be bad if we are running many commands with
-// large output (which is not uncommon at all). Consider
-// storing into files after a certain period has passed
-// or using a database.
+// Output is kept in a bounded in-memory ring buffer sized by
+// OutputHandlerConfig.RingBufferBytes; once a process's total output
+// exceeds that size, the oldest bytes are spilled to an append-only
+// file on disk (see spoolFile) so that long-running, noisy processes
+// do not grow the handler's memory footprint without bound.
 type OutputHandler struct {
 	listeners []*Listener
 	// readers is the readers that the output handler is extracting output from
 	// the readers slice is initialised on the constructor and should never
 	// change afterwards
 	readers []io.Reader
-	// combinedBuffer keeps the combined output, this is used to forward to
-	// new listeners that came in late
-	combinedBuffer []byte
+	// ring holds the most recent ringCap bytes of output, used to
+	// replay history to new listeners together with the spool file.
+	ring    []byte
+	ringCap int
+	// spool is where bytes evicted from ring are written, so the full
+	// history can still be replayed to a late listener.
+	spool     *spoolFile
+	retention RetentionPolicy
+	// queueDepth bounds the per-listener queue created in addListener
+	queueDepth int
+	// framer splits each reader's byte stream into forwarded frames
+	framer Framer
 	// messages is a channel where new output is delivered
-	messages chan []byte
+	messages chan frameMessage
+	// register is how addListener hands a new Listener to
+	// handleBroadcast, which alone decides whether it is still live or
+	// already at EOF and owns sending it its catch-up history; see
+	// addListener.
+	register chan *Listener
 	// errors is a channel to forward output reading errors to listeners
 	errors chan error
 	// done is a channel to notify listeners that all output has been read
@@ -55,26 +148,85 @@ type OutputHandler struct {
 type ProcessOutputEntry struct {
 	Content    []byte
 	ReceivedAt time.Time
+	// Stream is which of the process's output streams Content came
+	// from. Entries replaying buffered history (ring buffer or spool
+	// file) predate per-entry stream tagging and are reported as
+	// Stdout regardless of origin.
+	// TODO: tag replayed history per-stream too, once the ring buffer
+	// and spool file track stream boundaries instead of raw bytes.
+	Stream Stream
+}
+
+// frameMessage is a single framed chunk read from one of the handler's
+// readers, tagged with which stream it came from.
+type frameMessage struct {
+	content []byte
+	stream  Stream
 }
 
 // Listener represents an output listener that the output handler will
 // send updates to.
+//
+// The broadcaster only ever writes to queue, never to outputChan
+// directly: a dedicated forward goroutine drains queue into outputChan,
+// so a consumer that is slow to read outputChan cannot block the
+// broadcaster as long as queue still has room. Once queue is full, the
+// broadcaster disconnects the listener instead of blocking.
 type Listener struct {
+	queue      chan ProcessOutputEntry
 	outputChan chan ProcessOutputEntry
 	errorChan  chan error
 }
 
+// forward drains l.queue into l.outputChan until queue is closed, then
+// closes outputChan in turn.
+func (l *Listener) forward() {
+	for entry := range l.queue {
+		l.outputChan <- entry
+	}
+	close(l.outputChan)
+}
+
 // NewOutputHandler returns a new OutputHandler struct from the provided readers.
-func NewOutputHandler(rc ...io.Reader) (*OutputHandler, error) {
+func NewOutputHandler(cfg OutputHandlerConfig, rc ...io.Reader) (*OutputHandler, error) {
 	if len(rc) == 0 {
 		return nil, fmt.Errorf("must provide at least one io.Reader")
 	}
+
+	ringCap := cfg.RingBufferBytes
+	if ringCap <= 0 {
+		ringCap = DefaultRingBufferBytes
+	}
+	spoolDir := cfg.SpoolDir
+	if spoolDir == "" {
+		spoolDir = os.TempDir()
+	}
+	spool, err := newSpoolFile(spoolDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output spool file: %w", err)
+	}
+
+	queueDepth := cfg.ListenerQueueDepth
+	if queueDepth <= 0 {
+		queueDepth = DefaultListenerQueueDepth
+	}
+	framer := cfg.Framer
+	if framer == nil {
+		framer = FixedSizeFramer{}
+	}
+
 	o := &OutputHandler{
-		readers:  rc,
-		mu:       &sync.Mutex{},
-		done:     make(chan struct{}),
-		messages: make(chan []byte),
-		errors:   make(chan error),
+		readers:    rc,
+		ringCap:    ringCap,
+		spool:      spool,
+		retention:  cfg.Retention,
+		queueDepth: queueDepth,
+		framer:     framer,
+		mu:         &sync.Mutex{},
+		done:       make(chan struct{}),
+		messages:   make(chan frameMessage, messageQueueDepth),
+		register:   make(chan *Listener),
+		errors:     make(chan error),
 	}
 	go o.handleOutput()
 	go o.handleBroadcast()
@@ -91,78 +243,209 @@ func (o *OutputHandler) Stream() (<-chan ProcessOutputEntry, <-chan error) {
 	return o.addListener()
 }
 
+// Close releases resources held by the OutputHandler, namely its spool
+// file. It should be called once a process's output is no longer needed,
+// e.g. when it is removed from the worker's registry.
+func (o *OutputHandler) Close() error {
+	return o.spool.remove()
+}
+
 // addListener registers a new listener in the output handler and returns
 // channels that get all previous output and stream new output.
-// The whole operation is behind a lock as this cannot happen at the same
-// time as listeners are sent new output or the buffer is being updated.
+//
+// Registration itself happens inside handleBroadcast (see o.register):
+// o.done closing only means the readers have hit EOF, not that
+// handleBroadcast has finished draining o.messages into o.ring/the
+// spool, so deciding here whether a listener is late (EOF already
+// reached) would race that drain and could hand back an incomplete
+// history with no way to ever catch up. Funnelling registration
+// through the single goroutine that also owns that drain makes the
+// decision race-free.
 func (o *OutputHandler) addListener() (chan ProcessOutputEntry, chan error) {
+	output := make(chan ProcessOutputEntry)
+	errChan := make(chan error, 1)
+	l := &Listener{
+		queue:      make(chan ProcessOutputEntry, o.queueDepth),
+		outputChan: output,
+		errorChan:  errChan,
+	}
+	o.register <- l
+	go l.forward()
+	return output, errChan
+}
+
+// removeListener drops l from the listener set so it no longer receives
+// broadcasts. Used when a listener cannot keep up (see dropSlowListener).
+func (o *OutputHandler) removeListener(target *Listener) {
 	o.mu.Lock()
 	defer o.mu.Unlock()
-	// we are going to be sending the existing output entry which is at
-	// most one item
-	output := make(chan ProcessOutputEntry, 1)
-	errChan := make(chan error)
-
-	// catch up on existing output but only send it if there
-	// is anything to send.
-	if len(o.combinedBuffer) > 0 {
-		output <- ProcessOutputEntry{Content: o.combinedBuffer}
+	for i, l := range o.listeners {
+		if l == target {
+			o.listeners = append(o.listeners[:i], o.listeners[i+1:]...)
+			return
+		}
 	}
+}
+
+// dropSlowListener disconnects l because it fell behind queueDepth
+// messages: it is removed from the listener set, its queue is closed
+// (which closes its output channel in turn, via forward), and
+// ErrSlowConsumer is delivered on its error channel.
+func (o *OutputHandler) dropSlowListener(l *Listener) {
+	o.removeListener(l)
+	close(l.queue)
 	select {
-	case <-o.done:
-		close(output)
+	case l.errorChan <- ErrSlowConsumer:
 	default:
-		o.listeners = append(o.listeners, &Listener{
-			outputChan: output,
-			errorChan:  errChan,
-		})
 	}
-	return output, errChan
 }
 
-func (o *OutputHandler) updateCombinedBuffer(b []byte) {
+// appendOutput records b as newly read output, keeping at most ringCap
+// bytes in memory and spilling anything older to the spool file.
+func (o *OutputHandler) appendOutput(b []byte) error {
 	o.mu.Lock()
 	defer o.mu.Unlock()
-	o.combinedBuffer = append(o.combinedBuffer, b...)
+
+	combined := append(o.ring, b...)
+	if len(combined) > o.ringCap {
+		overflow := len(combined) - o.ringCap
+		if err := o.spool.write(combined[:overflow]); err != nil {
+			return fmt.Errorf("failed to spill output to disk: %w", err)
+		}
+		if !o.retention.KeepUntilDelete && o.retention.MaxBytes > 0 {
+			if err := o.spool.trimToMaxBytes(o.retention.MaxBytes); err != nil {
+				return fmt.Errorf("failed to apply output retention policy: %w", err)
+			}
+		}
+		combined = combined[overflow:]
+	}
+	o.ring = combined
+	return nil
 }
 
+// getListeners returns a copy of the current listener set, so that
+// callers ranging over it are unaffected by a concurrent
+// removeListener/dropSlowListener mutating o.listeners in place.
 func (o *OutputHandler) getListeners() []*Listener {
 	o.mu.Lock()
 	defer o.mu.Unlock()
-	return o.listeners[:len(o.listeners)]
+	listeners := make([]*Listener, len(o.listeners))
+	copy(listeners, o.listeners)
+	return listeners
 }
 
-// handleBroadcast forwads new messages to the listeners and
+// handleBroadcast forwards new messages to the listeners and
 // closes listener channels when done signal is received to
-// signal end of stream.
+// signal end of stream. A listener whose queue is full is disconnected
+// rather than blocking delivery to the rest.
 func (o *OutputHandler) handleBroadcast() {
-L:
+	broadcast := func(msg frameMessage) {
+		for _, l := range o.getListeners() {
+			select {
+			case l.queue <- ProcessOutputEntry{Content: msg.content, Stream: msg.stream}:
+			default:
+				o.dropSlowListener(l)
+			}
+		}
+		if err := o.appendOutput(msg.content); err != nil {
+			select {
+			case o.errors <- err:
+			default:
+			}
+		}
+	}
+
+	// register sends l the history accumulated so far (spool + ring,
+	// snapshotted together here, never concurrently with appendOutput
+	// since both only ever run on this goroutine) and then either adds
+	// l to the live listener set, so it keeps receiving broadcasts, or
+	// closes its queue outright because output has already finished.
+	// Owning both the snapshot and that live/finished decision here,
+	// rather than in addListener, is what keeps a listener that
+	// registers around EOF from ever seeing a gap between its history
+	// and the point it starts/stops receiving live messages.
+	register := func(l *Listener, live bool) {
+		history, err := o.spool.readAll()
+		if err != nil {
+			history = nil
+		}
+		o.mu.Lock()
+		if len(o.ring) > 0 {
+			history = append(history, o.ring...)
+		}
+		o.mu.Unlock()
+		if len(history) > 0 {
+			l.queue <- ProcessOutputEntry{Content: history}
+		}
+		if live {
+			o.mu.Lock()
+			o.listeners = append(o.listeners, l)
+			o.mu.Unlock()
+		} else {
+			close(l.queue)
+		}
+	}
+
+loop:
 	for {
+		// o.done is closed exactly once and, from then on, always ready;
+		// a single select between o.messages and o.done could therefore
+		// pick the done case even while messages are still sitting in
+		// the buffered channel, silently dropping the tail of the
+		// output. Draining o.messages non-blockingly first gives it
+		// priority over an already-closed done.
 		select {
 		case msg := <-o.messages:
-			for _, l := range o.getListeners() {
-				l.outputChan <- ProcessOutputEntry{Content: msg}
-			}
-			o.updateCombinedBuffer(msg)
+			broadcast(msg)
+			continue
+		default:
+		}
+
+		select {
+		case msg := <-o.messages:
+			broadcast(msg)
+		case l := <-o.register:
+			register(l, true)
 		case <-o.done:
-			for _, l := range o.getListeners() {
-				close(l.outputChan)
+			// o.messages may have gained entries, and o.register new
+			// listeners, between the two selects above; drain both
+			// before closing listeners for good.
+			for {
+				select {
+				case msg := <-o.messages:
+					broadcast(msg)
+				case l := <-o.register:
+					register(l, true)
+				default:
+					for _, l := range o.getListeners() {
+						close(l.queue)
+					}
+					break loop
+				}
 			}
-			break L
 		}
 	}
+
+	// Output has finished: every later registration gets history only,
+	// never added to o.listeners since no further broadcast is coming.
+	for l := range o.register {
+		register(l, false)
+	}
 }
 
-// handleErrors forwards errors to the listeners
+// handleErrors forwards errors to the listeners until done is closed.
 func (o *OutputHandler) handleErrors() {
-L:
 	for {
 		select {
 		case err := <-o.errors:
 			for _, l := range o.getListeners() {
-				l.errorChan <- err
+				select {
+				case l.errorChan <- err:
+				default:
+				}
 			}
-			break L
+		case <-o.done:
+			return
 		}
 	}
 }
@@ -179,11 +462,18 @@ func (o *OutputHandler) handleOutput() error {
 	// TODO: ideally, we would have a mechanism to halt
 	// all goroutines in this OutputHandler if there
 	// is an error. Like a quit channel.
-	for _, reader := range o.readers {
-		go func(r io.Reader) {
-			o.bufferAndForwardChunks(r)
+	//
+	// By convention (see NewOutputHandler), the first reader is Stdout
+	// and, if present, the second is Stderr.
+	for i, reader := range o.readers {
+		stream := Stdout
+		if i == 1 {
+			stream = Stderr
+		}
+		go func(r io.Reader, stream Stream) {
+			o.bufferAndForwardChunks(r, stream)
 			wg.Done()
-		}(reader)
+		}(reader, stream)
 	}
 
 	go func() {
@@ -195,24 +485,20 @@ func (o *OutputHandler) handleOutput() error {
 	return nil
 }
 
-// bufferAndForwardChunks reads the reader by 76 byte chunks
-// or it will stop at EOF. It buffers each chunk and forwards
-// it to the messages channel.
-func (o *OutputHandler) bufferAndForwardChunks(reader io.Reader) {
-	buf := make([]byte, 0, 76)
+// bufferAndForwardChunks reads reader frame by frame, as decided by
+// o.framer, until the frame reader reports EOF. Each frame is forwarded
+// to the messages channel tagged with stream.
+func (o *OutputHandler) bufferAndForwardChunks(reader io.Reader, stream Stream) {
+	frameReader := o.framer.NewFrameReader(reader)
 	for {
-		n, err := io.ReadFull(reader, buf[:cap(buf)])
-		buf = buf[:n]
+		frame, err := frameReader.ReadFrame()
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
-			if err != io.ErrUnexpectedEOF {
-				o.errors <- fmt.Errorf("failed to read output: %v", err)
-				break
-			}
+			o.errors <- fmt.Errorf("failed to read output: %v", err)
+			break
 		}
-
-		o.messages <- buf
+		o.messages <- frameMessage{content: frame, stream: stream}
 	}
 }
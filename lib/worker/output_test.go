@@ -3,7 +3,10 @@ package worker_test
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/trivelle/worker/lib/worker"
@@ -12,7 +15,7 @@ import (
 func TestOutputHandlerEmptyReader(t *testing.T) {
 	var stdoutReader bytes.Buffer
 
-	outputHandler, err := worker.NewOutputHandler(&stdoutReader)
+	outputHandler, err := worker.NewOutputHandler(worker.OutputHandlerConfig{}, &stdoutReader)
 	assert.Nil(t, err)
 	outputChan, _ := outputHandler.Stream()
 
@@ -36,18 +39,25 @@ func TestOutputHandlerSuccess(t *testing.T) {
 	_, err = stdErrReader.WriteString("some error")
 	assert.Nil(t, err, "error setting up reader")
 
-	outputHandler, err := worker.NewOutputHandler(&stdoutReader, &stdErrReader)
+	outputHandler, err := worker.NewOutputHandler(worker.OutputHandlerConfig{}, &stdoutReader, &stdErrReader)
 	assert.Nil(t, err)
 	outputChan, _ := outputHandler.Stream()
 
-	expectedOutputLines := []string{"some output line 1\nsome output line 2\nsome output line 3", "some error"}
-
-	var outputLines []string
+	// Ordering across stdout and stderr is not guaranteed, but each
+	// entry is now tagged with the stream it came from, so a consumer
+	// can reconstruct per-stream order even when interleaved.
+	var stdoutContent, stderrContent string
 	for out := range outputChan {
-		outputLines = append(outputLines, string(out.Content))
+		switch out.Stream {
+		case worker.Stdout:
+			stdoutContent += string(out.Content)
+		case worker.Stderr:
+			stderrContent += string(out.Content)
+		}
 	}
 
-	assert.ElementsMatch(t, outputLines, expectedOutputLines)
+	assert.Equal(t, "some output line 1\nsome output line 2\nsome output line 3", stdoutContent)
+	assert.Equal(t, "some error", stderrContent)
 }
 
 // errorReader is a fake reader that throws errors for testing
@@ -58,6 +68,66 @@ func (r *errorReader) Read(p []byte) (n int, err error) {
 	return 0, fmt.Errorf("errorReader returns errors")
 }
 
+func TestOutputHandlerSpillsToDiskBeyondRingBuffer(t *testing.T) {
+	var stdoutReader bytes.Buffer
+	_, err := stdoutReader.WriteString("some output line 1\nsome output line 2\nsome output line 3")
+	assert.Nil(t, err, "error setting up reader")
+
+	outputHandler, err := worker.NewOutputHandler(worker.OutputHandlerConfig{RingBufferBytes: 8}, &stdoutReader)
+	assert.Nil(t, err)
+	outputChan, _ := outputHandler.Stream()
+
+	var gotContent []byte
+	for out := range outputChan {
+		gotContent = append(gotContent, out.Content...)
+	}
+
+	assert.Equal(t, "some output line 1\nsome output line 2\nsome output line 3", string(gotContent))
+
+	// A listener attaching after the process finished should still be
+	// replayed the full history, even though most of it was spilled to
+	// the spool file rather than kept in the 8-byte ring buffer.
+	lateChan, _ := outputHandler.Stream()
+	var lateContent []byte
+	for out := range lateChan {
+		lateContent = append(lateContent, out.Content...)
+	}
+	assert.Equal(t, "some output line 1\nsome output line 2\nsome output line 3", string(lateContent))
+}
+
+func TestOutputHandlerDisconnectsSlowConsumer(t *testing.T) {
+	// An io.Pipe, rather than a bytes.Buffer, so each chunk is only
+	// handed to the OutputHandler once the test writes it: with a
+	// bytes.Buffer, the whole history is typically drained into the ring
+	// before Stream() is even called, so the listener only ever sees one
+	// coalesced history entry and never overflows its queue.
+	pr, pw := io.Pipe()
+
+	outputHandler, err := worker.NewOutputHandler(worker.OutputHandlerConfig{ListenerQueueDepth: 1}, pr)
+	assert.Nil(t, err)
+	_, errChan := outputHandler.Stream()
+
+	// Feed 76-byte chunks one at a time, now that a listener is attached
+	// and nobody is reading its output channel, to overflow its 1-deep
+	// queue.
+	chunk := []byte(strings.Repeat("x", 76))
+	go func() {
+		for i := 0; i < 10; i++ {
+			if _, err := pw.Write(chunk); err != nil {
+				return
+			}
+		}
+		pw.Close()
+	}()
+
+	select {
+	case gotErr := <-errChan:
+		assert.Equal(t, worker.ErrSlowConsumer, gotErr)
+	case <-time.After(time.Second):
+		t.Fatal("expected ErrSlowConsumer, got nothing")
+	}
+}
+
 func TestOutputHandlerError(t *testing.T) {
 	var stdoutReader bytes.Buffer
 	errorReader := &errorReader{}
@@ -65,7 +135,7 @@ func TestOutputHandlerError(t *testing.T) {
 	_, err := stdoutReader.WriteString("some output line 1\nsome output line 2\nsome output line 3")
 	assert.Nil(t, err, "error setting up reader")
 
-	outputHandler, err := worker.NewOutputHandler(&stdoutReader, errorReader)
+	outputHandler, err := worker.NewOutputHandler(worker.OutputHandlerConfig{}, &stdoutReader, errorReader)
 	outputChan, errChan := outputHandler.Stream()
 
 	for range outputChan {
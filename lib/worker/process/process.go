@@ -1,32 +1,75 @@
 package process
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"os"
 	"os/exec"
-	"strings"
 	"sync"
+	"syscall"
 	"time"
+
+	"github.com/creack/pty"
+	"github.com/trivelle/worker/lib/worker/process/procfs"
 )
 
+// DefaultGracefulStopInitialInterval is how long StopGraceful waits
+// before its first check-in on whether the process has exited.
+const DefaultGracefulStopInitialInterval = 250 * time.Millisecond
+
+// DefaultGracefulStopMaxInterval caps how long StopGraceful's
+// exponential backoff grows to between check-ins.
+const DefaultGracefulStopMaxInterval = 4 * time.Second
+
+// DefaultGracefulStopWindow is the total time StopGraceful waits for
+// the process to exit on its own before escalating to SIGKILL, used
+// when ctx has no deadline of its own.
+const DefaultGracefulStopWindow = 30 * time.Second
+
+// ErrProcessGone is returned by Stop, StopGraceful and GetProcessStatus
+// when the PID this Process was started with now belongs to a different
+// process, as detected by comparing /proc/[pid]/stat's starttime
+// against the value recorded at Start time. Linux recycles PIDs
+// aggressively, so without this check a long-lived Worker could end up
+// signalling or reporting on an unrelated process.
+var ErrProcessGone = errors.New("process no longer exists: pid has been reused")
+
 // Process is a Process executed or to be executed by the worker
 // TODO: Find a better name for this as the word "Process" implies
 // that this it is already running.
 type Process struct {
-	command    string
 	cmd        *exec.Cmd
 	startedBy  string
 	startedAt  time.Time
-	finishedAt time.Time // TODO: implement finished at
+	finishedAt time.Time
+	exitCode   *int
+	signal     syscall.Signal
+	rusage     *Rusage
 	mu         sync.Mutex
+
+	// startTimeTicks is /proc/[pid]/stat's starttime field, recorded
+	// right after the process starts, and re-checked before Stop and
+	// GetProcessStatus act on p.cmd.Process.Pid. Zero if it could not be
+	// read at start time, in which case the check is skipped.
+	startTimeTicks uint64
+
+	// ptmx is the PTY master fd, set by StartPTY instead of Start when
+	// the process was requested with a TTY.
+	ptmx *os.File
+
+	// done is closed by wait once the process has exited and its final
+	// status has been recorded.
+	done chan struct{}
 }
 
-func NewProcess(command string, cmd *exec.Cmd, startedBy string) *Process {
+func NewProcess(cmd *exec.Cmd, startedBy string) *Process {
 	return &Process{
-		command:   command,
 		cmd:       cmd,
 		startedBy: startedBy,
 		mu:        sync.Mutex{},
+		done:      make(chan struct{}),
 	}
 }
 
@@ -36,25 +79,196 @@ type ProcessStatus struct {
 	StartedBy  string
 	State      string
 	StartedAt  time.Time
-	FinishedAt time.Time // TODO: implement finished at
+	FinishedAt time.Time
+
+	// ExitCode is the process's exit code. Nil until the process exits.
+	ExitCode *int
+
+	// Signal is the signal that terminated the process, if any.
+	Signal syscall.Signal
+
+	// Rusage is the resource usage the kernel accounted against the
+	// process, as reported by wait4(2) once it has exited. Nil while
+	// still running.
+	Rusage *Rusage
+
+	// CPUTime is the total time the process has spent scheduled, in
+	// user and kernel mode combined, as of this snapshot.
+	CPUTime time.Duration
+
+	// MemoryRSS is the process's resident set size, in bytes, as of
+	// this snapshot.
+	MemoryRSS int64
+
+	// StartTimeTicks is the process's start time, in clock ticks after
+	// boot, as reported by the kernel. Use procfs.Stat.StartedAt to
+	// convert it to a wall-clock time.Time.
+	StartTimeTicks uint64
+
+	// ParentPID is the PID of this process's parent.
+	ParentPID int
+
+	// NumThreads is the number of threads in the process.
+	NumThreads int64
+
+	// Namespaces holds the process's namespace inode IDs, keyed by
+	// namespace type (see procfs.ReadNamespaces), for auditing what
+	// isolation it is actually running under. Nil if it could not be
+	// read (e.g. insufficient permissions).
+	Namespaces map[string]string
+
+	// OOMKilled is set by the worker when the process is running under
+	// a cgroup and the kernel has recorded an OOM kill against it.
+	OOMKilled bool
 }
 
 // Start starts a process. It can only be done once otherwise
 // it will return an error
 func (p *Process) Start() error {
 	p.mu.Lock()
-	defer p.mu.Unlock()
 	if p.cmd.Process != nil {
+		p.mu.Unlock()
 		return fmt.Errorf("process already started")
 	}
 	p.startedAt = time.Now()
 	err := p.cmd.Start()
+	if err == nil {
+		p.recordStartTime()
+	}
+	p.mu.Unlock()
 	if err != nil {
 		return err
 	}
+	go p.wait()
 	return nil
 }
 
+// recordStartTime reads the freshly-started process's /proc/[pid]/stat
+// starttime, so later Stop/GetProcessStatus calls can detect PID reuse.
+// Must be called with p.mu held and p.cmd.Process already set.
+// Best-effort: if the read fails, startTimeTicks is left at zero and the
+// PID-reuse check is skipped rather than failing the start.
+func (p *Process) recordStartTime() {
+	stat, err := procfs.ReadStat(p.getPid())
+	if err != nil {
+		return
+	}
+	p.startTimeTicks = stat.StartTime
+}
+
+// StartPTY starts a process attached to a newly allocated pseudo-terminal,
+// sized to ws, and returns a reader for the PTY's master end. The
+// child's stdin, stdout and stderr are all attached to the slave end, so
+// interactive programs (shells, REPLs) see a real controlling terminal.
+// It can only be done once, like Start.
+func (p *Process) StartPTY(ws *pty.Winsize) (io.Reader, error) {
+	p.mu.Lock()
+	if p.cmd.Process != nil {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("process already started")
+	}
+	p.startedAt = time.Now()
+	ptmx, err := pty.StartWithSize(p.cmd, ws)
+	if err != nil {
+		p.mu.Unlock()
+		return nil, err
+	}
+	p.ptmx = ptmx
+	p.recordStartTime()
+	p.mu.Unlock()
+	go p.wait()
+	return ptyEOFReader{ptmx}, nil
+}
+
+// ptyEOFReader wraps a PTY master, translating the EIO a read returns
+// once the slave side has closed (i.e. the child has exited) into the
+// io.EOF ordinary readers expect. Without this, any reader looping on
+// the PTY master (io.ReadAll, the worker package's output forwarding,
+// ...) sees a spurious read failure on every clean exit instead of a
+// normal end of stream.
+type ptyEOFReader struct {
+	r io.Reader
+}
+
+func (r ptyEOFReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if errors.Is(err, syscall.EIO) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Rusage is the resource usage the kernel accounted against a process
+// over its lifetime, as reported by wait4(2).
+type Rusage struct {
+	// UTime and STime are time spent executing in user and kernel mode,
+	// respectively.
+	UTime time.Duration
+	STime time.Duration
+
+	// MaxRSS is the process's peak resident set size, in kilobytes.
+	MaxRSS int64
+}
+
+// wait blocks until the process exits, recording its exit code,
+// terminating signal (if any) and resource usage, and closing done so
+// that callers can block on completion via Done() instead of polling
+// /proc/<pid>/stat.
+func (p *Process) wait() {
+	p.cmd.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.finishedAt = time.Now()
+	if state := p.cmd.ProcessState; state != nil {
+		code := state.ExitCode()
+		p.exitCode = &code
+		if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			p.signal = ws.Signal()
+		}
+		if ru, ok := state.SysUsage().(*syscall.Rusage); ok && ru != nil {
+			p.rusage = &Rusage{
+				UTime:  time.Duration(ru.Utime.Sec)*time.Second + time.Duration(ru.Utime.Usec)*time.Microsecond,
+				STime:  time.Duration(ru.Stime.Sec)*time.Second + time.Duration(ru.Stime.Usec)*time.Microsecond,
+				MaxRSS: int64(ru.Maxrss),
+			}
+		}
+	}
+	close(p.done)
+}
+
+// Done returns a channel that is closed once the process has exited and
+// its final ProcessStatus fields (ExitCode, Signal, FinishedAt) have
+// been recorded, so callers can block on completion instead of polling
+// GetProcessStatus.
+func (p *Process) Done() <-chan struct{} {
+	return p.done
+}
+
+// ResizePTY changes the window size of the process's PTY.
+// Returns an error if the process was not started with a PTY.
+func (p *Process) ResizePTY(ws *pty.Winsize) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ptmx == nil {
+		return fmt.Errorf("process was not started with a PTY")
+	}
+	return pty.Setsize(p.ptmx, ws)
+}
+
+// WriteInput writes b to the process's PTY master, as if typed at the
+// controlling terminal. Returns an error if the process was not started
+// with a PTY.
+func (p *Process) WriteInput(b []byte) (int, error) {
+	p.mu.Lock()
+	ptmx := p.ptmx
+	p.mu.Unlock()
+	if ptmx == nil {
+		return 0, fmt.Errorf("process was not started with a PTY")
+	}
+	return ptmx.Write(b)
+}
+
 // Stop stops a process. It should only be done once
 // Subsequent calls to Stop would either be a noop
 // or return "os: process already released" error
@@ -66,12 +280,105 @@ func (p *Process) Stop() error {
 	if p.cmd.Process == nil {
 		return fmt.Errorf("process not started")
 	}
+	if !p.finishedAt.IsZero() {
+		// Already exited and reaped; nothing left to stop.
+		return nil
+	}
+	if err := p.verifyAlive(); err != nil {
+		return err
+	}
+	if p.ptmx != nil {
+		p.ptmx.Close()
+	}
 	return p.cmd.Process.Kill()
 }
 
+// verifyAlive re-checks /proc/[pid]/stat's starttime against
+// startTimeTicks, returning ErrProcessGone on a mismatch or if the PID
+// can no longer be read at all. Must be called with p.mu held and
+// p.cmd.Process already set. A zero startTimeTicks (it could not be
+// read at Start time) skips the check.
+func (p *Process) verifyAlive() error {
+	if p.startTimeTicks == 0 {
+		return nil
+	}
+	stat, err := procfs.ReadStat(p.getPid())
+	if err != nil || stat.StartTime != p.startTimeTicks {
+		return ErrProcessGone
+	}
+	return nil
+}
+
+// StopGraceful stops the process by sending initialSignal (typically
+// syscall.SIGTERM) and then waiting for it to exit, checking in with
+// exponential backoff (starting at DefaultGracefulStopInitialInterval,
+// doubling up to DefaultGracefulStopMaxInterval) rather than busy-polling.
+// If ctx carries no deadline, DefaultGracefulStopWindow is applied. If
+// the deadline elapses first, the process is escalated to SIGKILL via
+// Stop and StopGraceful waits once more for it to take effect.
+//
+// Exit is detected via the done channel closed by the wait goroutine
+// started in Start/StartPTY, rather than re-polling
+// /proc/[pid]/stat: wait already calls cmd.Wait(), which is the
+// authoritative, race-free signal that the process has exited, so a
+// second procfs-based liveness check here would only be slower and
+// redundant. Liveness (i.e. that the PID hasn't been reused) before
+// signalling is still verified the same way Stop does, via verifyAlive.
+//
+// Returns the process's final status, so callers can distinguish a
+// graceful exit (Signal == initialSignal) from a forced one
+// (Signal == syscall.SIGKILL).
+func (p *Process) StopGraceful(ctx context.Context, initialSignal syscall.Signal) (*ProcessStatus, error) {
+	p.mu.Lock()
+	if p.cmd.Process == nil {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("process not started")
+	}
+	if !p.finishedAt.IsZero() {
+		defer p.mu.Unlock()
+		return p.getProcessStatusLocked()
+	}
+	if err := p.verifyAlive(); err != nil {
+		p.mu.Unlock()
+		return nil, err
+	}
+	proc := p.cmd.Process
+	done := p.done
+	p.mu.Unlock()
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultGracefulStopWindow)
+		defer cancel()
+	}
+
+	if err := proc.Signal(initialSignal); err != nil {
+		return nil, err
+	}
+
+	interval := DefaultGracefulStopInitialInterval
+	for {
+		select {
+		case <-done:
+			return p.GetProcessStatus()
+		case <-ctx.Done():
+			if err := p.Stop(); err != nil && err != ErrProcessGone {
+				return nil, err
+			}
+			<-done
+			return p.GetProcessStatus()
+		case <-time.After(interval):
+			interval *= 2
+			if interval > DefaultGracefulStopMaxInterval {
+				interval = DefaultGracefulStopMaxInterval
+			}
+		}
+	}
+}
+
 // Command returns the command of the process
 func (p *Process) Command() string {
-	return p.command
+	return p.cmd.Path
 }
 
 func (p *Process) getPid() int {
@@ -81,27 +388,92 @@ func (p *Process) getPid() int {
 	return p.cmd.Process.Pid
 }
 
+// PID returns the pid of the process once it has been started.
+// Otherwise, an invalid pid of 0 is returned.
+func (p *Process) PID() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.getPid()
+}
+
 // GetProcessStatus returns ProcessStatus corresponding to the
 // process as a point in time status of the process.
 func (p *Process) GetProcessStatus() (*ProcessStatus, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.getProcessStatusLocked()
+}
+
+// getProcessStatusLocked is GetProcessStatus's implementation. Must be
+// called with p.mu held, so that callers that already hold the lock
+// (e.g. WithStatus) can reuse it without recursing on a non-reentrant
+// sync.Mutex.
+func (p *Process) getProcessStatusLocked() (*ProcessStatus, error) {
 	if p.cmd.Process == nil {
 		return nil, fmt.Errorf("process not started")
 	}
 	pid := p.getPid()
-	state, err := retrieveProcessState(pid)
+
+	if !p.finishedAt.IsZero() {
+		// wait has already reaped the process, so /proc/<pid> no longer
+		// has an entry for it; derive the terminal state from our own
+		// bookkeeping instead of reading procfs.
+		state := "exited"
+		if p.signal != 0 {
+			state = "signaled"
+		}
+		return &ProcessStatus{
+			PID:        pid,
+			StartedBy:  p.startedBy,
+			State:      state,
+			StartedAt:  p.startedAt,
+			FinishedAt: p.finishedAt,
+			ExitCode:   p.exitCode,
+			Signal:     p.signal,
+			Rusage:     p.rusage,
+		}, nil
+	}
+
+	stat, err := procfs.ReadStat(pid)
 	if err != nil {
 		return nil, err
 	}
+	if p.startTimeTicks != 0 && stat.StartTime != p.startTimeTicks {
+		return nil, ErrProcessGone
+	}
+
+	// Best-effort: namespace inodes are purely informational, so a
+	// permission error reading them shouldn't fail the whole status.
+	namespaces, _ := procfs.ReadNamespaces(pid)
 
 	return &ProcessStatus{
-		PID:        pid,
-		StartedBy:  p.startedBy,
-		State:      state,
-		StartedAt:  p.startedAt,
-		FinishedAt: p.finishedAt,
+		PID:            pid,
+		StartedBy:      p.startedBy,
+		State:          stat.State,
+		StartedAt:      p.startedAt,
+		FinishedAt:     p.finishedAt,
+		ExitCode:       p.exitCode,
+		Signal:         p.signal,
+		CPUTime:        cpuTime(stat),
+		MemoryRSS:      stat.RSS * int64(os.Getpagesize()),
+		StartTimeTicks: stat.StartTime,
+		ParentPID:      stat.PPID,
+		NumThreads:     stat.NumThreads,
+		Namespaces:     namespaces,
 	}, nil
 }
 
+// cpuTime combines a Stat's user and kernel mode scheduled time into a
+// single time.Duration.
+func cpuTime(stat *procfs.Stat) time.Duration {
+	return time.Duration(stat.UTime+stat.STime) * time.Second / clockTicksPerSecond
+}
+
+// clockTicksPerSecond mirrors procfs's USER_HZ assumption; CPUTime is
+// derived from the same /proc/[pid]/stat fields procfs parses, so it
+// must use the same ticks-to-seconds conversion.
+const clockTicksPerSecond = 100
+
 // WithStatus calls f ensuring that the ProcessStatus is not changed
 // by the worker until f finishes. This does not guarantee that the
 // OS will not change the status of the process. For example, memory
@@ -110,35 +482,9 @@ func (p *Process) GetProcessStatus() (*ProcessStatus, error) {
 func (p *Process) WithStatus(f func(*ProcessStatus) error) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	processStatus, err := p.GetProcessStatus()
+	processStatus, err := p.getProcessStatusLocked()
 	if err != nil {
 		return err
 	}
 	return f(processStatus)
 }
-
-// retrieveProcessState retrieves the Linux process state i.e.
-// one of R, D, S, T or Z.
-// TODO: this could belong to its own internal os package but for now I think
-// it is fine here.
-func retrieveProcessState(pid int) (string, error) {
-	statPath := fmt.Sprintf("/proc/%d/stat", pid)
-	dataBytes, err := ioutil.ReadFile(statPath)
-	if err != nil {
-		return "", nil
-	}
-
-	// Move past the image name as process state is right after
-	data := string(dataBytes)
-	binStart := strings.IndexRune(data, '(') + 1
-	binEnd := strings.IndexRune(data[binStart:], ')')
-	data = data[binStart+binEnd+2:]
-
-	splittedData := strings.Split(data, " ")
-	if len(splittedData) < 1 {
-		return "", fmt.Errorf("malformed proc stat data")
-	}
-	state := splittedData[0]
-
-	return state, nil
-}
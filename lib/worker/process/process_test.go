@@ -1,11 +1,17 @@
 package process
 
 import (
+	"bufio"
+	"context"
+	"io"
 	"os/exec"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/creack/pty"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -86,6 +92,138 @@ func TestProcessRestartAfterStop(t *testing.T) {
 	assert.EqualError(t, err, "process already started")
 }
 
+func TestProcessStartPTY(t *testing.T) {
+	cmd := exec.Command("echo", "hello")
+
+	process := NewProcess(cmd, "some_user")
+
+	ptmx, err := process.StartPTY(&pty.Winsize{Rows: 24, Cols: 80})
+	assert.Nil(t, err)
+	defer process.Stop()
+
+	out, err := io.ReadAll(ptmx)
+	assert.Nil(t, err)
+	assert.Contains(t, string(out), "hello")
+}
+
+func TestProcessWriteInputWithoutPTY(t *testing.T) {
+	cmd := exec.Command("echo", "hello")
+
+	process := NewProcess(cmd, "some_user")
+
+	_, err := process.WriteInput([]byte("hi\n"))
+	assert.EqualError(t, err, "process was not started with a PTY")
+}
+
+func TestProcessDoneAndExitCode(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "exit 3")
+
+	process := NewProcess(cmd, "some_user")
+
+	err := process.Start()
+	assert.Nil(t, err)
+
+	select {
+	case <-process.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Done() did not close after process exited")
+	}
+
+	status, err := process.GetProcessStatus()
+	assert.Nil(t, err)
+	assert.Equal(t, "exited", status.State)
+	assert.NotNil(t, status.ExitCode)
+	assert.Equal(t, 3, *status.ExitCode)
+	assert.False(t, status.FinishedAt.IsZero())
+}
+
+func TestProcessRusageAndSignaledState(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+
+	process := NewProcess(cmd, "some_user")
+	err := process.Start()
+	assert.Nil(t, err)
+
+	err = process.Stop()
+	assert.Nil(t, err)
+
+	select {
+	case <-process.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Done() did not close after process was killed")
+	}
+
+	status, err := process.GetProcessStatus()
+	assert.Nil(t, err)
+	assert.Equal(t, "signaled", status.State)
+	assert.Equal(t, syscall.SIGKILL, status.Signal)
+	assert.NotNil(t, status.Rusage)
+}
+
+func TestProcessStopGracefulExitsOnSIGTERM(t *testing.T) {
+	// The default disposition of SIGTERM is to terminate, so a plain
+	// sleep exits on its own once signalled, without needing SIGKILL.
+	cmd := exec.Command("sleep", "10")
+
+	process := NewProcess(cmd, "some_user")
+	err := process.Start()
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	status, err := process.StopGraceful(ctx, syscall.SIGTERM)
+	assert.Nil(t, err)
+	assert.Equal(t, syscall.SIGTERM, status.Signal)
+}
+
+func TestProcessStopGracefulEscalatesToSIGKILL(t *testing.T) {
+	// Ignoring SIGTERM forces StopGraceful to fall back to SIGKILL once
+	// the deadline elapses. The child prints a marker once the trap is
+	// installed and StopGraceful isn't called until that marker is seen,
+	// so the SIGTERM can't race the trap's own setup.
+	cmd := exec.Command("sh", "-c", "trap '' TERM; echo ready; sleep 10")
+	stdout, err := cmd.StdoutPipe()
+	assert.Nil(t, err)
+
+	process := NewProcess(cmd, "some_user")
+	err = process.Start()
+	assert.Nil(t, err)
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "ready\n", line)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	status, err := process.StopGraceful(ctx, syscall.SIGTERM)
+	assert.Nil(t, err)
+	assert.Equal(t, syscall.SIGKILL, status.Signal)
+}
+
+func TestProcessDetectsPIDReuse(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+
+	process := NewProcess(cmd, "some_user")
+	err := process.Start()
+	assert.Nil(t, err)
+
+	// Simulate the PID having been reused by another process: the
+	// starttime recorded at Start no longer matches what /proc/[pid]/stat
+	// reports for the (still running, in this test, but now "different")
+	// process at that PID.
+	process.startTimeTicks = 1
+
+	_, err = process.GetProcessStatus()
+	assert.Equal(t, ErrProcessGone, err)
+
+	err = process.Stop()
+	assert.Equal(t, ErrProcessGone, err)
+
+	// Restore the real starttime so cleanup can actually stop the process.
+	process.startTimeTicks = 0
+	assert.Nil(t, process.Stop())
+}
+
 func TestProcessStopBeforeStart(t *testing.T) {
 	cmd := exec.Command("echo", "hello")
 
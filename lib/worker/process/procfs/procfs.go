@@ -0,0 +1,193 @@
+// Package procfs reads process accounting information from Linux's
+// /proc/[pid]/stat, the same source the process package used to poll
+// one field at a time from.
+package procfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicksPerSecond is the kernel's USER_HZ, i.e. the unit StartTime,
+// UTime and STime are expressed in. Go has no portable sysconf(3)
+// binding without cgo; USER_HZ has been 100 on every mainstream Linux
+// distribution in practice, so it is hardcoded here rather than pulling
+// in a cgo dependency for it.
+const clockTicksPerSecond = 100
+
+// Stat is a single point-in-time snapshot of /proc/[pid]/stat, read and
+// parsed in one syscall rather than re-reading the file per field.
+// Field names and order follow proc(5); only the fields the worker
+// currently needs are exposed.
+type Stat struct {
+	PID  int
+	Comm string
+
+	// State is one of R, S, D, Z, T, etc. See proc(5) for the full set.
+	State string
+
+	PPID       int
+	PGrp       int
+	Session    int
+	TTYNr      int
+	UTime      uint64 // time scheduled in user mode, in clock ticks
+	STime      uint64 // time scheduled in kernel mode, in clock ticks
+	NumThreads int64
+
+	// StartTime is the time the process started, in clock ticks after
+	// boot. Use StartedAt to convert it to a wall-clock time.Time.
+	StartTime uint64
+
+	VSize uint64 // virtual memory size, in bytes
+	RSS   int64  // resident set size, in pages
+}
+
+// ReadStat reads and parses /proc/[pid]/stat for pid.
+//
+// Comm (the second field) is parenthesized and may itself contain
+// spaces or parentheses, so it cannot be split on alone; instead, this
+// scans for the *last* ')' in the line, which proc(5) guarantees is the
+// end of comm, and parses everything after it as the documented,
+// space-separated, fixed-order fields.
+func ReadStat(pid int) (*Stat, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+	return parseStat(string(data))
+}
+
+func parseStat(line string) (*Stat, error) {
+	line = strings.TrimRight(line, "\n")
+
+	commStart := strings.IndexByte(line, '(')
+	commEnd := strings.LastIndexByte(line, ')')
+	if commStart < 0 || commEnd < 0 || commEnd < commStart {
+		return nil, fmt.Errorf("malformed proc stat data: missing comm field")
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(line[:commStart]))
+	if err != nil {
+		return nil, fmt.Errorf("malformed proc stat data: invalid pid: %w", err)
+	}
+	comm := line[commStart+1 : commEnd]
+
+	// Fields 3 onwards (state, ppid, pgrp, ...), space-separated.
+	fields := strings.Fields(line[commEnd+1:])
+	const (
+		idxState = iota
+		idxPPID
+		idxPGrp
+		idxSession
+		idxTTYNr
+		idxTPGid
+		idxFlags
+		idxMinFlt
+		idxCMinFlt
+		idxMajFlt
+		idxCMajFlt
+		idxUTime
+		idxSTime
+		idxCUTime
+		idxCSTime
+		idxPriority
+		idxNice
+		idxNumThreads
+		idxITRealValue
+		idxStartTime
+		idxVSize
+		idxRSS
+	)
+	if len(fields) <= idxRSS {
+		return nil, fmt.Errorf("malformed proc stat data: expected at least %d fields after comm, got %d", idxRSS+1, len(fields))
+	}
+
+	atoi := func(i int) int {
+		v, _ := strconv.Atoi(fields[i])
+		return v
+	}
+	atou64 := func(i int) uint64 {
+		v, _ := strconv.ParseUint(fields[i], 10, 64)
+		return v
+	}
+	atoi64 := func(i int) int64 {
+		v, _ := strconv.ParseInt(fields[i], 10, 64)
+		return v
+	}
+
+	return &Stat{
+		PID:        pid,
+		Comm:       comm,
+		State:      fields[idxState],
+		PPID:       atoi(idxPPID),
+		PGrp:       atoi(idxPGrp),
+		Session:    atoi(idxSession),
+		TTYNr:      atoi(idxTTYNr),
+		UTime:      atou64(idxUTime),
+		STime:      atou64(idxSTime),
+		NumThreads: atoi64(idxNumThreads),
+		StartTime:  atou64(idxStartTime),
+		VSize:      atou64(idxVSize),
+		RSS:        atoi64(idxRSS),
+	}, nil
+}
+
+// BootTime returns the system's boot time, read from the "btime" line
+// of /proc/stat, the same reference point StartTime ticks are counted
+// from.
+func BootTime() (time.Time, error) {
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "btime ") {
+			continue
+		}
+		secs, err := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(line, "btime ")), 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("malformed /proc/stat btime: %w", err)
+		}
+		return time.Unix(secs, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("btime not found in /proc/stat")
+}
+
+// ReadNamespaces reads the namespace inode IDs a process belongs to,
+// from the /proc/[pid]/ns/* symlinks (e.g. "pid:[4026531836]"), keyed
+// by namespace type ("pid", "mnt", "uts", "ipc", "net", "user", ...).
+// Useful for auditing what isolation a process actually ended up
+// running under, e.g. confirming it is not sharing a namespace with
+// the worker itself.
+func ReadNamespaces(pid int) (map[string]string, error) {
+	dir := fmt.Sprintf("/proc/%d/ns", pid)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	namespaces := make(map[string]string, len(entries))
+	for _, e := range entries {
+		target, err := os.Readlink(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		namespaces[e.Name()] = target
+	}
+	return namespaces, nil
+}
+
+// StartedAt converts s.StartTime, which is in clock ticks since boot,
+// into an absolute time.Time, so callers can report an accurate
+// StartedAt even when re-attaching to a process they did not start
+// themselves.
+func (s *Stat) StartedAt() (time.Time, error) {
+	boot, err := BootTime()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return boot.Add(time.Duration(s.StartTime) * time.Second / clockTicksPerSecond), nil
+}
@@ -0,0 +1,51 @@
+package procfs
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStatWithParensInComm(t *testing.T) {
+	// comm can itself contain spaces and parentheses (e.g. "(sd-pam)"),
+	// so parsing must scan for the *last* ')' rather than the first.
+	line := "1234 (my (weird) app) S 1 1234 1234 0 -1 4194304 100 0 0 0 200 50 0 0 20 0 4 0 987654 123456789 4096 18446744073709551615\n"
+
+	stat, err := parseStat(line)
+	assert.Nil(t, err)
+	assert.Equal(t, 1234, stat.PID)
+	assert.Equal(t, "my (weird) app", stat.Comm)
+	assert.Equal(t, "S", stat.State)
+	assert.Equal(t, 1, stat.PPID)
+	assert.Equal(t, uint64(200), stat.UTime)
+	assert.Equal(t, uint64(50), stat.STime)
+	assert.Equal(t, int64(4), stat.NumThreads)
+	assert.Equal(t, uint64(987654), stat.StartTime)
+	assert.Equal(t, uint64(123456789), stat.VSize)
+	assert.Equal(t, int64(4096), stat.RSS)
+}
+
+func TestParseStatMalformed(t *testing.T) {
+	_, err := parseStat("not a stat line")
+	assert.NotNil(t, err)
+}
+
+func TestReadStatSelf(t *testing.T) {
+	stat, err := ReadStat(os.Getpid())
+	assert.Nil(t, err)
+	assert.Equal(t, os.Getpid(), stat.PID)
+}
+
+func TestBootTime(t *testing.T) {
+	boot, err := BootTime()
+	assert.Nil(t, err)
+	assert.False(t, boot.IsZero())
+}
+
+func TestReadNamespacesSelf(t *testing.T) {
+	namespaces, err := ReadNamespaces(os.Getpid())
+	assert.Nil(t, err)
+	assert.Contains(t, namespaces, "pid")
+	assert.Contains(t, namespaces["pid"], "pid:[")
+}
@@ -0,0 +1,85 @@
+package worker
+
+import (
+	"os"
+	"sync"
+)
+
+// spoolFile is a per-process append-only file that output evicted from
+// an OutputHandler's in-memory ring buffer is written to, so that a late
+// listener can still be replayed the full history of a long-running,
+// noisy process.
+type spoolFile struct {
+	mu      sync.Mutex
+	path    string
+	f       *os.File
+	written int64
+}
+
+// newSpoolFile creates a new, empty spool file under dir.
+func newSpoolFile(dir string) (*spoolFile, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(dir, "worker-output-*.spool")
+	if err != nil {
+		return nil, err
+	}
+	return &spoolFile{path: f.Name(), f: f}, nil
+}
+
+// write appends b to the spool file.
+func (s *spoolFile) write(b []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, err := s.f.Write(b)
+	s.written += int64(n)
+	return err
+}
+
+// readAll returns the full content of the spool file written so far.
+func (s *spoolFile) readAll() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return os.ReadFile(s.path)
+}
+
+// trimToMaxBytes drops the oldest bytes in the spool file so that no
+// more than max bytes remain, implementing RetentionPolicy.MaxBytes.
+func (s *spoolFile) trimToMaxBytes(max int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.written <= max {
+		return nil
+	}
+
+	// TODO: this reads and rewrites the whole file on every trim, which
+	// is fine for the modest retention windows this is meant for but
+	// would need a smarter (e.g. block-aligned) approach for very large
+	// MaxBytes values.
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	trimmed := data[int64(len(data))-max:]
+
+	if err := s.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := s.f.Seek(0, 0); err != nil {
+		return err
+	}
+	if _, err := s.f.Write(trimmed); err != nil {
+		return err
+	}
+	s.written = int64(len(trimmed))
+	return nil
+}
+
+// remove closes and deletes the spool file.
+func (s *spoolFile) remove() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.f.Close()
+	return os.Remove(s.path)
+}
@@ -2,10 +2,15 @@
 package worker
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
 	"sync"
+	"syscall"
 
+	"github.com/creack/pty"
 	"github.com/google/uuid"
 	"github.com/trivelle/worker/lib/worker/process"
 )
@@ -17,6 +22,24 @@ import (
 type Worker struct {
 	processRegistry map[ID]*ProcessHandle
 	mu              *sync.RWMutex
+	cgroups         *cgroupManager
+	outputConfig    OutputHandlerConfig
+}
+
+// Config is the configuration for a Worker instance
+type Config struct {
+	// RootCgroup is the path to a cgroup v2 directory, delegated to this
+	// process, under which the worker creates one child cgroup per
+	// started process. If empty, DefaultRootCgroup is used.
+	RootCgroup string
+
+	// ResourceLimitsDefault are the ResourceLimits applied to a
+	// ProcessRequest that does not specify its own.
+	ResourceLimitsDefault ResourceLimits
+
+	// Output configures how started processes' output is buffered and,
+	// once it outgrows the in-memory ring buffer, spilled to disk.
+	Output OutputHandlerConfig
 }
 
 // ProcessRequest represents a request to execute a Linux process in the worker
@@ -33,15 +56,40 @@ type ProcessRequest struct {
 
 	// RequestedBy is the user that requested this process request
 	RequestedBy string
+
+	// TTY requests that the process be allocated a pseudo-terminal
+	// instead of plain stdout/stderr pipes, so interactive programs
+	// (shells, REPLs) behave as they would at a real terminal.
+	TTY bool
+
+	// WindowSize is the initial PTY window size. Only used when TTY is
+	// true; defaults to 80x24 if left zero.
+	WindowSize WindowSize
+
+	// Framer overrides how this process's output is split into frames.
+	// Nil uses the Worker's Config.Output.Framer.
+	Framer Framer
+
+	// Isolation configures the Linux namespaces, chroot and credentials
+	// the process is started with. The zero value starts the process
+	// with no isolation, sharing the worker's own namespaces.
+	Isolation IsolationConfig
+}
+
+// WindowSize is a PTY's size, in rows and columns of text.
+type WindowSize struct {
+	Rows uint16
+	Cols uint16
 }
 
 // NewWorker creates an instance of a Worker
 func NewWorker(cfg Config) *Worker {
 	registry := make(map[ID]*ProcessHandle)
 	return &Worker{
-		// resourceLimits: cfg.resourceLimitsDefault,
 		processRegistry: registry,
 		mu:              &sync.RWMutex{},
+		cgroups:         newCgroupManager(cfg.RootCgroup),
+		outputConfig:    cfg.Output,
 	}
 }
 
@@ -52,60 +100,231 @@ type ID string
 type ProcessHandle struct {
 	process       *process.Process
 	outputHandler *OutputHandler
+	// cgroup is nil when the process was started without ResourceLimits
+	// or cgroup setup failed and was not required.
+	cgroup *cgroup
+}
+
+// Done returns a channel that is closed once the process has exited, so
+// callers can block on completion instead of polling GetProcessStatus.
+func (h *ProcessHandle) Done() <-chan struct{} {
+	return h.process.Done()
 }
 
 // ResourceLimits is a struct that holds requested resource limits
 // in a process request. These translate to cgroup interface files.
 type ResourceLimits struct {
+	// MaxMemoryBytes translates to the cgroup's memory.max
 	MaxMemoryBytes int64
-	// ...
+
+	// CPUMax translates to the cgroup's cpu.max. A zero value leaves
+	// the quota unset (unlimited).
+	CPUMax CPUMax
+
+	// CPUWeight translates to the cgroup's cpu.weight (1-10000). A zero
+	// value leaves the weight at the cgroup's default.
+	CPUWeight uint64
+
+	// IOMax translates to one line per entry in the cgroup's io.max.
+	IOMax []IOMax
+
+	// PidsMax translates to the cgroup's pids.max. A zero value leaves
+	// the limit unset (unlimited).
+	PidsMax int64
+}
+
+// isZero reports whether limits requests no actual constraint, in which
+// case the worker can skip cgroup setup entirely.
+func (r ResourceLimits) isZero() bool {
+	return r.MaxMemoryBytes == 0 && r.CPUMax == (CPUMax{}) && r.CPUWeight == 0 &&
+		len(r.IOMax) == 0 && r.PidsMax == 0
+}
+
+// CPUMax is the quota/period pair written to the cgroup's cpu.max,
+// both in microseconds.
+type CPUMax struct {
+	QuotaMicros  int64
+	PeriodMicros int64
+}
+
+// IOMax is a single per-device line written to the cgroup's io.max.
+// Major/Minor identify the device, as reported by `lsblk -o MAJ:MIN`.
+type IOMax struct {
+	Major int64
+	Minor int64
+	RBPS  int64
+	WBPS  int64
+	RIOPS int64
+	WIOPS int64
 }
 
 // StartProcess starts a new process and adds it to the worker
 // process registry. It does not wait for the process to terminate.
 func (w *Worker) StartProcess(req ProcessRequest) (ID, error) {
 	cmd := exec.Command(req.Command, req.Args...)
-
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return "", err
+	if err := applyIsolation(cmd, req.Isolation); err != nil {
+		return "", fmt.Errorf("failed to configure process isolation: %w", err)
 	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return "", err
+
+	// Plain stdout/stderr pipes are created (and owned) here rather
+	// than via cmd.StdoutPipe/StderrPipe: those convenience pipes
+	// register their read end in cmd's closeAfterWait list, so Wait
+	// closes it the instant the process exits, before the reader goroutine
+	// is necessarily even scheduled - per os/exec's own documented
+	// caveat ("it is incorrect to call Wait before all reads from the
+	// pipe have completed"), a fast enough process reliably loses
+	// output to it. Owning the pipes ourselves means only we ever
+	// close the read end, and only after reading it to EOF, so a
+	// slow-to-be-scheduled reader can never lose data: the read end
+	// only sees EOF once every writer of it - our own copy, closed
+	// right after Start below, and the child's - has closed.
+	var stdout, stderr *os.File
+	var stdoutW, stderrW *os.File
+	if !req.TTY {
+		var err error
+		stdout, stdoutW, err = os.Pipe()
+		if err != nil {
+			return "", err
+		}
+		cmd.Stdout = stdoutW
+		stderr, stderrW, err = os.Pipe()
+		if err != nil {
+			stdout.Close()
+			stdoutW.Close()
+			return "", err
+		}
+		cmd.Stderr = stderrW
 	}
 
-	process := process.NewProcess(cmd, req.RequestedBy)
+	proc := process.NewProcess(cmd, req.RequestedBy)
+
+	id := ID(uuid.NewString())
+
+	var cg *cgroup
+	if !req.ResourceLimits.isZero() {
+		var err error
+		cg, err = w.cgroups.newChild(id)
+		if err != nil {
+			return "", fmt.Errorf("failed to set up cgroup for process: %w", err)
+		}
+		if err := cg.apply(req.ResourceLimits); err != nil {
+			cg.remove()
+			return "", fmt.Errorf("failed to apply resource limits: %w", err)
+		}
+		// CLONE_INTO_CGROUP clones the child directly into cg, so it
+		// never executes outside its limits even for the brief window
+		// before a separately-issued cgroup.procs write would otherwise
+		// take effect.
+		cgroupDir, err := applyCgroupFD(cmd, cg)
+		if err != nil {
+			cg.remove()
+			return "", fmt.Errorf("failed to prepare cgroup for process: %w", err)
+		}
+		defer cgroupDir.Close()
+	}
 
-	err = process.Start()
+	var ptmx io.Reader
+	var err error
+	if req.TTY {
+		ptmx, err = proc.StartPTY(req.WindowSize.toPTY())
+	} else {
+		err = proc.Start()
+		// Our copy of the write end must be closed for the read end to
+		// ever see EOF: otherwise it would still count as an open
+		// writer (alongside the child's own copy) even once the child
+		// exits, and a read past that point would just block forever
+		// instead of returning EOF.
+		stdoutW.Close()
+		stderrW.Close()
+	}
 	if err != nil {
+		if !req.TTY {
+			stdout.Close()
+			stderr.Close()
+		}
+		if cg != nil {
+			cg.remove()
+		}
 		return "", err
 	}
 
-	id := ID(uuid.NewString())
+	outputConfig := w.outputConfig
+	if req.Framer != nil {
+		outputConfig.Framer = req.Framer
+	}
 
-	outputHandler, err := NewOutputHandler(stdout, stderr)
+	var outputHandler *OutputHandler
+	if req.TTY {
+		outputHandler, err = NewOutputHandler(outputConfig, ptmx)
+	} else {
+		outputHandler, err = NewOutputHandler(outputConfig, stdout, stderr)
+	}
 	if err != nil {
 		return "", err
 	}
 
 	processHandle := &ProcessHandle{
-		process:       process,
+		process:       proc,
 		outputHandler: outputHandler,
+		cgroup:        cg,
 	}
 
 	w.addToRegistry(id, processHandle)
 	return id, nil
 }
 
-// getProcess extracts an *Process instance from the process registry
-func (w *Worker) getProcess(processId ID) (*process.Process, error) {
+// toPTY converts a WindowSize into the pty.Winsize the process package
+// expects, defaulting to 80x24 when left unset.
+func (ws WindowSize) toPTY() *pty.Winsize {
+	rows, cols := ws.Rows, ws.Cols
+	if rows == 0 {
+		rows = 24
+	}
+	if cols == 0 {
+		cols = 80
+	}
+	return &pty.Winsize{Rows: rows, Cols: cols}
+}
+
+// ResizePTY changes the window size of a process's PTY.
+// Returns an error if the process was not started with TTY: true.
+func (w *Worker) ResizePTY(processId ID, rows, cols uint16) error {
+	proc, err := w.getProcess(processId)
+	if err != nil {
+		return err
+	}
+	return proc.ResizePTY(&pty.Winsize{Rows: rows, Cols: cols})
+}
+
+// WriteInput writes b to a process's PTY master, as if typed at its
+// controlling terminal. Returns an error if the process was not started
+// with TTY: true.
+func (w *Worker) WriteInput(processId ID, b []byte) (int, error) {
+	proc, err := w.getProcess(processId)
+	if err != nil {
+		return 0, err
+	}
+	return proc.WriteInput(b)
+}
+
+// getProcessHandle extracts the full ProcessHandle from the process
+// registry, needed by callers that also care about the process cgroup.
+func (w *Worker) getProcessHandle(processId ID) (*ProcessHandle, error) {
 	if handle, ok := w.getFromRegistry(processId); ok {
-		return handle.process, nil
+		return handle, nil
 	}
 	return nil, fmt.Errorf("no process with ID %s", processId)
 }
 
+// getProcess extracts a *process.Process instance from the process registry
+func (w *Worker) getProcess(processId ID) (*process.Process, error) {
+	handle, err := w.getProcessHandle(processId)
+	if err != nil {
+		return nil, err
+	}
+	return handle.process, nil
+}
+
 // getOutputHandler extracts an output handler instance from the process registry
 func (w *Worker) getOutputHandler(processId ID) (*OutputHandler, error) {
 	if handle, ok := w.getFromRegistry(processId); ok {
@@ -133,21 +352,105 @@ func (w *Worker) getFromRegistry(processId ID) (*ProcessHandle, bool) {
 // Returns an error if errors are encountered stopping the process
 // or the process does not exist in the worker registry.
 func (w *Worker) StopProcess(processId ID) error {
-	proc, err := w.getProcess(processId)
+	handle, err := w.getProcessHandle(processId)
 	if err != nil {
 		return err
 	}
-	return proc.Stop()
+	if err := handle.process.Stop(); err != nil {
+		return err
+	}
+	if handle.cgroup != nil {
+		// remove requires the cgroup to be empty of processes; Stop only
+		// sends SIGKILL, it does not wait for the kernel to reap the
+		// child and drop it from cgroup.procs.
+		<-handle.Done()
+		return handle.cgroup.remove()
+	}
+	return nil
+}
+
+// StopProcessGraceful stops a process currently managed by the worker,
+// sending initialSignal (typically syscall.SIGTERM) and waiting for it
+// to exit, escalating to SIGKILL once ctx is done. A ctx with no
+// deadline gets process.DefaultGracefulStopWindow applied.
+func (w *Worker) StopProcessGraceful(ctx context.Context, processId ID, initialSignal syscall.Signal) (*process.ProcessStatus, error) {
+	handle, err := w.getProcessHandle(processId)
+	if err != nil {
+		return nil, err
+	}
+	status, err := handle.process.StopGraceful(ctx, initialSignal)
+	if err != nil {
+		return nil, err
+	}
+	if handle.cgroup != nil {
+		if err := handle.cgroup.remove(); err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
+
+// WaitProcess blocks until processId's process has exited, then returns
+// its final ProcessStatus (ExitCode, Signal and FinishedAt populated).
+// Callers that already hold a ProcessHandle can instead block on
+// Done() directly; WaitProcess is for callers that only have an ID.
+func (w *Worker) WaitProcess(processId ID) (*process.ProcessStatus, error) {
+	handle, err := w.getProcessHandle(processId)
+	if err != nil {
+		return nil, err
+	}
+	<-handle.Done()
+	return w.GetProcessStatus(processId)
 }
 
 // GetProcessStatus gives access to the ProcessStatus struct
 // which provides a point in time view of the process status.
 func (w *Worker) GetProcessStatus(processId ID) (*process.ProcessStatus, error) {
-	proc, err := w.getProcess(processId)
+	handle, err := w.getProcessHandle(processId)
+	if err != nil {
+		return nil, err
+	}
+	status, err := handle.process.GetProcessStatus()
 	if err != nil {
 		return nil, err
 	}
-	return proc.GetProcessStatus()
+	if handle.cgroup != nil {
+		oomKilled, err := handle.cgroup.oomKilled()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cgroup OOM events: %w", err)
+		}
+		status.OOMKilled = oomKilled
+	}
+	return status, nil
+}
+
+// DeleteProcess removes a process from the worker's registry. The
+// process must not be running; callers should StopProcess (or wait for
+// natural exit) first.
+func (w *Worker) DeleteProcess(processId ID) error {
+	handle, err := w.getProcessHandle(processId)
+	if err != nil {
+		return err
+	}
+	status, err := handle.process.GetProcessStatus()
+	if err != nil {
+		return err
+	}
+	if status.FinishedAt.IsZero() {
+		return fmt.Errorf("process %s is still running", processId)
+	}
+
+	w.mu.Lock()
+	delete(w.processRegistry, processId)
+	w.mu.Unlock()
+
+	if err := handle.outputHandler.Close(); err != nil {
+		return err
+	}
+	if handle.cgroup != nil {
+		return handle.cgroup.remove()
+	}
+	return nil
 }
 
 // StreamProcessOutput returns an instance of a Streamer that
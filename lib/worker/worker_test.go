@@ -0,0 +1,351 @@
+package worker_test
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trivelle/worker/lib/worker"
+)
+
+// cgroupV2Root returns a fresh child directory under the real cgroup v2
+// mount, for tests that need a delegated cgroup to create children
+// under. A plain directory outside the real cgroupfs mount (e.g. under
+// t.TempDir()) is not itself a cgroup, so this discovers the actual
+// mountpoint rather than assuming a fixed layout: on a hybrid v1/v2
+// system the v2 hierarchy is not necessarily mounted at
+// /sys/fs/cgroup.
+func cgroupV2Root(t *testing.T) string {
+	t.Helper()
+
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		t.Skipf("cannot read /proc/self/mountinfo: %v", err)
+	}
+	defer f.Close()
+
+	var mount string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// mountinfo separates its fixed fields from the filesystem type
+		// with a literal "-"; the filesystem type follows immediately.
+		for i, field := range fields {
+			if field == "-" && i+1 < len(fields) && fields[i+1] == "cgroup2" {
+				mount = fields[4]
+			}
+		}
+	}
+	if mount == "" {
+		t.Skip("no cgroup v2 mount found")
+	}
+
+	root := filepath.Join(mount, "worker-test-"+strings.ReplaceAll(t.Name(), "/", "_"))
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatalf("failed to create delegated test cgroup %s: %v", root, err)
+	}
+	t.Cleanup(func() { os.Remove(root) })
+	return root
+}
+
+// populateChrootWithLs copies the host's ls binary, and every shared
+// library ldd(1) reports it needs, into root at the same absolute
+// paths they live at on the host. exec resolves cmd.Path inside the
+// chroot (chroot(2) runs before execve in the child), so a chroot test
+// that wants to actually exec something needs that something, and
+// everything it's dynamically linked against, physically present
+// under root first.
+func populateChrootWithLs(t *testing.T, root string) {
+	t.Helper()
+
+	lsPath, err := exec.LookPath("ls")
+	if err != nil {
+		t.Skipf("ls not found on PATH: %v", err)
+	}
+	copyIntoChroot(t, root, lsPath)
+
+	out, err := exec.Command("ldd", lsPath).Output()
+	if err != nil {
+		t.Fatalf("ldd %s: %v", lsPath, err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		var libPath string
+		switch {
+		case len(fields) >= 3 && fields[1] == "=>" && strings.HasPrefix(fields[2], "/"):
+			libPath = fields[2]
+		case len(fields) >= 1 && strings.HasPrefix(fields[0], "/"):
+			libPath = fields[0]
+		}
+		if libPath != "" {
+			copyIntoChroot(t, root, libPath)
+		}
+	}
+}
+
+// copyIntoChroot copies the file at path into root, preserving path's
+// absolute location (e.g. /lib64/ld-linux-x86-64.so.2 ends up at
+// <root>/lib64/ld-linux-x86-64.so.2).
+func copyIntoChroot(t *testing.T, root, path string) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading %s: %v", path, err)
+	}
+	dst := filepath.Join(root, path)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		t.Fatalf("creating %s: %v", filepath.Dir(dst), err)
+	}
+	if err := os.WriteFile(dst, data, 0755); err != nil {
+		t.Fatalf("writing %s: %v", dst, err)
+	}
+}
+
+func TestWorkerStartProcessAndGetStatus(t *testing.T) {
+	w := worker.NewWorker(worker.Config{})
+
+	id, err := w.StartProcess(worker.ProcessRequest{
+		Command:     "sleep",
+		Args:        []string{"10"},
+		RequestedBy: "some_user",
+	})
+	assert.Nil(t, err)
+	defer w.StopProcess(id)
+
+	status, err := w.GetProcessStatus(id)
+	assert.Nil(t, err)
+	assert.Equal(t, "some_user", status.StartedBy)
+	assert.True(t, status.FinishedAt.IsZero())
+}
+
+func TestWorkerGetProcessStatusUnknownID(t *testing.T) {
+	w := worker.NewWorker(worker.Config{})
+
+	_, err := w.GetProcessStatus(worker.ID("does-not-exist"))
+	assert.Error(t, err)
+}
+
+func TestWorkerWaitProcessReturnsExitCode(t *testing.T) {
+	w := worker.NewWorker(worker.Config{})
+
+	id, err := w.StartProcess(worker.ProcessRequest{
+		Command:     "sh",
+		Args:        []string{"-c", "exit 7"},
+		RequestedBy: "some_user",
+	})
+	assert.Nil(t, err)
+
+	status, err := w.WaitProcess(id)
+	assert.Nil(t, err)
+	assert.NotNil(t, status.ExitCode)
+	assert.Equal(t, 7, *status.ExitCode)
+}
+
+func TestWorkerStopProcessRemovesIsReadyForDelete(t *testing.T) {
+	w := worker.NewWorker(worker.Config{})
+
+	id, err := w.StartProcess(worker.ProcessRequest{
+		Command:     "sleep",
+		Args:        []string{"10"},
+		RequestedBy: "some_user",
+	})
+	assert.Nil(t, err)
+
+	err = w.StopProcess(id)
+	assert.Nil(t, err)
+
+	_, err = w.WaitProcess(id)
+	assert.Nil(t, err)
+
+	err = w.DeleteProcess(id)
+	assert.Nil(t, err)
+
+	_, err = w.GetProcessStatus(id)
+	assert.Error(t, err)
+}
+
+func TestWorkerStopProcessWithResourceLimitsThenStatus(t *testing.T) {
+	w := worker.NewWorker(worker.Config{RootCgroup: cgroupV2Root(t)})
+
+	id, err := w.StartProcess(worker.ProcessRequest{
+		Command:     "sleep",
+		Args:        []string{"10"},
+		RequestedBy: "some_user",
+		ResourceLimits: worker.ResourceLimits{
+			MaxMemoryBytes: 64 * 1024 * 1024,
+		},
+	})
+	if err != nil {
+		t.Fatalf("StartProcess: %v", err)
+	}
+
+	if err := w.StopProcess(id); err != nil {
+		t.Fatalf("StopProcess: %v", err)
+	}
+
+	// StopProcess removes the cgroup directory once the process is
+	// reaped; GetProcessStatus must still succeed afterwards instead of
+	// failing to read the now-deleted memory.events.
+	status, err := w.WaitProcess(id)
+	if err != nil {
+		t.Fatalf("WaitProcess: %v", err)
+	}
+	assert.NotNil(t, status.Signal)
+}
+
+func TestWorkerStopProcessGracefulWithResourceLimitsThenStatus(t *testing.T) {
+	w := worker.NewWorker(worker.Config{RootCgroup: cgroupV2Root(t)})
+
+	id, err := w.StartProcess(worker.ProcessRequest{
+		Command:     "sleep",
+		Args:        []string{"10"},
+		RequestedBy: "some_user",
+		ResourceLimits: worker.ResourceLimits{
+			MaxMemoryBytes: 64 * 1024 * 1024,
+		},
+	})
+	if err != nil {
+		t.Fatalf("StartProcess: %v", err)
+	}
+
+	if _, err := w.StopProcessGraceful(context.Background(), id, syscall.SIGTERM); err != nil {
+		t.Fatalf("StopProcessGraceful: %v", err)
+	}
+
+	// StopProcessGraceful removes the cgroup directory itself once the
+	// process has exited; a later GetProcessStatus must still succeed
+	// instead of failing to read the now-deleted memory.events.
+	status, err := w.GetProcessStatus(id)
+	if err != nil {
+		t.Fatalf("GetProcessStatus: %v", err)
+	}
+	assert.False(t, status.OOMKilled)
+}
+
+func TestWorkerStreamProcessOutput(t *testing.T) {
+	w := worker.NewWorker(worker.Config{})
+
+	id, err := w.StartProcess(worker.ProcessRequest{
+		Command:     "echo",
+		Args:        []string{"hello"},
+		RequestedBy: "some_user",
+	})
+	assert.Nil(t, err)
+
+	outChan, _, err := w.StreamProcessOutput(id)
+	assert.Nil(t, err)
+
+	var content string
+	for entry := range outChan {
+		content += string(entry.Content)
+	}
+	assert.Contains(t, content, "hello")
+}
+
+func TestWorkerStartProcessAppliesMemoryLimitAndDetectsOOM(t *testing.T) {
+	w := worker.NewWorker(worker.Config{RootCgroup: cgroupV2Root(t)})
+
+	// /dev/shm is tmpfs, so writing to it counts against the writing
+	// process's memory cgroup: this reliably exceeds a 10 MiB limit and
+	// triggers the kernel OOM killer within the cgroup.
+	id, err := w.StartProcess(worker.ProcessRequest{
+		Command:     "dd",
+		Args:        []string{"if=/dev/zero", "of=/dev/shm/worker-oom-test", "bs=1M", "count=200"},
+		RequestedBy: "some_user",
+		ResourceLimits: worker.ResourceLimits{
+			MaxMemoryBytes: 10 * 1024 * 1024,
+		},
+	})
+	if err != nil {
+		t.Fatalf("StartProcess: %v", err)
+	}
+	defer os.Remove("/dev/shm/worker-oom-test")
+
+	status, err := w.WaitProcess(id)
+	if err != nil {
+		t.Fatalf("WaitProcess: %v", err)
+	}
+	assert.True(t, status.OOMKilled)
+}
+
+func TestWorkerStartProcessIsolationNamespaceAndHostname(t *testing.T) {
+	w := worker.NewWorker(worker.Config{})
+
+	id, err := w.StartProcess(worker.ProcessRequest{
+		Command:     "hostname",
+		RequestedBy: "some_user",
+		Isolation: worker.IsolationConfig{
+			Namespaces: worker.NamespaceFlags{UTS: true},
+			Hostname:   "worker-isolation-test",
+		},
+	})
+	assert.Nil(t, err)
+
+	outChan, _, err := w.StreamProcessOutput(id)
+	assert.Nil(t, err)
+
+	var content string
+	for entry := range outChan {
+		content += string(entry.Content)
+	}
+	assert.Contains(t, content, "worker-isolation-test")
+}
+
+func TestWorkerStartProcessIsolationChroot(t *testing.T) {
+	w := worker.NewWorker(worker.Config{})
+
+	chroot := t.TempDir()
+	marker := "only-visible-inside-chroot"
+	require.NoError(t, os.WriteFile(filepath.Join(chroot, marker), []byte("x"), 0644))
+	populateChrootWithLs(t, chroot)
+
+	id, err := w.StartProcess(worker.ProcessRequest{
+		Command:     "ls",
+		Args:        []string{"/"},
+		RequestedBy: "some_user",
+		Isolation: worker.IsolationConfig{
+			Chroot: chroot,
+		},
+	})
+	require.NoError(t, err)
+
+	outChan, _, err := w.StreamProcessOutput(id)
+	require.NoError(t, err)
+
+	var content string
+	for entry := range outChan {
+		content += string(entry.Content)
+	}
+	assert.Contains(t, content, marker)
+}
+
+func TestWorkerStartProcessIsolationCredential(t *testing.T) {
+	w := worker.NewWorker(worker.Config{})
+
+	id, err := w.StartProcess(worker.ProcessRequest{
+		Command:     "id",
+		Args:        []string{"-u"},
+		RequestedBy: "some_user",
+		Isolation: worker.IsolationConfig{
+			Credential: &worker.Credential{UID: 65534, GID: 65534},
+		},
+	})
+	assert.Nil(t, err)
+
+	outChan, _, err := w.StreamProcessOutput(id)
+	assert.Nil(t, err)
+
+	var content string
+	for entry := range outChan {
+		content += string(entry.Content)
+	}
+	assert.Equal(t, "65534\n", content)
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/trivelle/worker/lib/worker"
+	"github.com/trivelle/worker/lib/worker/process"
 )
 
 func main() {
@@ -34,7 +35,7 @@ func main() {
 		i := i
 		time.Sleep(time.Millisecond)
 		go func() {
-			ouputChan, _ := w.StreamProcessOutput(id)
+			ouputChan, _, _ := w.StreamProcessOutput(id)
 			for line := range ouputChan {
 				fmt.Printf("got output in %d: %s\n", i, string(line.Content))
 			}
@@ -44,7 +45,7 @@ func main() {
 	wg.Wait()
 }
 
-func printInfo(info *worker.ProcessStatus) {
+func printInfo(info *process.ProcessStatus) {
 	fmt.Printf("pid: %v\n", info.PID)
 	fmt.Printf("started_by: %s\n", info.StartedBy)
 
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/trivelle/worker/pkg/api/workerpb"
+)
+
+// Client is a thin wrapper around a WorkerServiceClient connection,
+// mirroring worker.Worker's method set for remote callers.
+type Client struct {
+	conn *grpc.ClientConn
+	rpc  workerpb.WorkerServiceClient
+}
+
+// Dial connects to a WorkerService at addr using the given mTLS config.
+func Dial(addr string, tlsCfg TLSConfig) (*Client, error) {
+	creds, err := clientTransportCreds(tlsCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client TLS config: %w", err)
+	}
+
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s: %w", addr, err)
+	}
+	return &Client{conn: conn, rpc: workerpb.NewWorkerServiceClient(conn)}, nil
+}
+
+// Close closes the underlying connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// StartProcess starts a process on the remote worker and returns its ID.
+func (c *Client) StartProcess(ctx context.Context, command string, args []string, maxMemoryBytes int64) (string, error) {
+	resp, err := c.rpc.CreateProcess(ctx, &workerpb.CreateProcessRequest{
+		Command: command,
+		Args:    args,
+		ResourceLimits: &workerpb.ResourceLimits{
+			MaxMemoryBytes: maxMemoryBytes,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.GetId(), nil
+}
+
+// StopProcess stops a process on the remote worker.
+func (c *Client) StopProcess(ctx context.Context, id string) error {
+	_, err := c.rpc.StopProcess(ctx, &workerpb.StopProcessRequest{Id: id})
+	return err
+}
+
+// GetProcessStatus returns the remote worker's view of a process's status.
+func (c *Client) GetProcessStatus(ctx context.Context, id string) (*workerpb.ProcessStatus, error) {
+	return c.rpc.GetProcessStatus(ctx, &workerpb.GetProcessStatusRequest{Id: id})
+}
+
+// StreamProcessOutput streams a remote process's output until the server
+// closes the stream or ctx is cancelled.
+func (c *Client) StreamProcessOutput(ctx context.Context, id string) (workerpb.WorkerService_StreamProcessOutputClient, error) {
+	return c.rpc.StreamProcessOutput(ctx, &workerpb.StreamProcessOutputRequest{Id: id})
+}
+
+// Delete removes a finished process from the remote worker's registry.
+func (c *Client) Delete(ctx context.Context, id string) error {
+	_, err := c.rpc.Delete(ctx, &workerpb.DeleteRequest{Id: id})
+	return err
+}
+
+func clientTransportCreds(cfg TLSConfig) (credentials.TransportCredentials, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client cert/key: %w", err)
+	}
+
+	caPEM, err := os.ReadFile(cfg.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("failed to parse CA file %s", cfg.CAFile)
+	}
+
+	return credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      caPool,
+	}), nil
+}
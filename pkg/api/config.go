@@ -0,0 +1,24 @@
+package api
+
+// Config is the configuration for a Server.
+type Config struct {
+	// Addr is the address the server listens on, e.g. ":9090".
+	Addr string
+
+	// TLS holds the server's mTLS configuration. Required: a server
+	// started without TLS refuses to serve RPCs.
+	TLS TLSConfig
+}
+
+// TLSConfig configures mutual TLS for the gRPC server and client. Client
+// certificates are required and verified against CAFile, and the
+// certificate's CommonName is used as the caller identity that populates
+// ProcessRequest.RequestedBy server-side.
+type TLSConfig struct {
+	// CertFile and KeyFile are this end's certificate and private key.
+	CertFile string
+	KeyFile  string
+
+	// CAFile is the CA used to verify the peer's certificate.
+	CAFile string
+}
@@ -0,0 +1,184 @@
+// Package api exposes a worker.Worker as a remote gRPC service. The wire
+// types (WorkerServiceServer, WorkerServiceClient, and the request/response
+// messages) are generated from pkg/api/proto/worker.proto via `make proto`
+// into pkg/api/workerpb; this package hand-writes the glue between that
+// generated service and lib/worker.
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/trivelle/worker/lib/worker"
+	"github.com/trivelle/worker/pkg/api/workerpb"
+)
+
+// Server is a gRPC front end for a worker.Worker.
+type Server struct {
+	workerpb.UnimplementedWorkerServiceServer
+
+	worker *worker.Worker
+	grpc   *grpc.Server
+	addr   string
+}
+
+// NewServer returns a Server that dispatches to w, configured with the
+// given mTLS settings and listen address.
+func NewServer(w *worker.Worker, cfg Config) (*Server, error) {
+	creds, err := serverTransportCreds(cfg.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server TLS config: %w", err)
+	}
+
+	s := &Server{worker: w, addr: cfg.Addr}
+	s.grpc = grpc.NewServer(grpc.Creds(creds))
+	workerpb.RegisterWorkerServiceServer(s.grpc, s)
+	return s, nil
+}
+
+// Serve blocks accepting connections on the Config.Addr given to
+// NewServer until the server is stopped or listening fails.
+func (s *Server) Serve() error {
+	lis, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.addr, err)
+	}
+	return s.ServeListener(lis)
+}
+
+// ServeListener blocks accepting connections on lis until the server is
+// stopped or accepting fails. It is split out from Serve so tests can
+// serve over an in-memory listener (e.g. bufconn) without binding a
+// real port.
+func (s *Server) ServeListener(lis net.Listener) error {
+	return s.grpc.Serve(lis)
+}
+
+// Stop gracefully stops the server, waiting for in-flight RPCs (including
+// StreamProcessOutput streams) to finish.
+func (s *Server) Stop() {
+	s.grpc.GracefulStop()
+}
+
+// CreateProcess implements workerpb.WorkerServiceServer.
+func (s *Server) CreateProcess(ctx context.Context, req *workerpb.CreateProcessRequest) (*workerpb.CreateProcessResponse, error) {
+	id, err := s.worker.StartProcess(worker.ProcessRequest{
+		Command: req.GetCommand(),
+		Args:    req.GetArgs(),
+		ResourceLimits: worker.ResourceLimits{
+			MaxMemoryBytes: req.GetResourceLimits().GetMaxMemoryBytes(),
+		},
+		RequestedBy: requestedBy(ctx, req.GetRequestedBy()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &workerpb.CreateProcessResponse{Id: string(id)}, nil
+}
+
+// StopProcess implements workerpb.WorkerServiceServer.
+func (s *Server) StopProcess(ctx context.Context, req *workerpb.StopProcessRequest) (*workerpb.StopProcessResponse, error) {
+	if err := s.worker.StopProcess(worker.ID(req.GetId())); err != nil {
+		return nil, err
+	}
+	return &workerpb.StopProcessResponse{}, nil
+}
+
+// GetProcessStatus implements workerpb.WorkerServiceServer.
+func (s *Server) GetProcessStatus(ctx context.Context, req *workerpb.GetProcessStatusRequest) (*workerpb.ProcessStatus, error) {
+	status, err := s.worker.GetProcessStatus(worker.ID(req.GetId()))
+	if err != nil {
+		return nil, err
+	}
+	return &workerpb.ProcessStatus{
+		Pid:        int32(status.PID),
+		StartedBy:  status.StartedBy,
+		State:      status.State,
+		StartedAt:  timestamppb.New(status.StartedAt),
+		FinishedAt: timestamppb.New(status.FinishedAt),
+		OomKilled:  status.OOMKilled,
+	}, nil
+}
+
+// StreamProcessOutput implements workerpb.WorkerServiceServer.
+func (s *Server) StreamProcessOutput(req *workerpb.StreamProcessOutputRequest, stream workerpb.WorkerService_StreamProcessOutputServer) error {
+	outputChan, errChan, err := s.worker.StreamProcessOutput(worker.ID(req.GetId()))
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case entry, ok := <-outputChan:
+			if !ok {
+				return nil
+			}
+			if err := stream.Send(&workerpb.ProcessOutputEntry{
+				Content:    entry.Content,
+				ReceivedAt: timestamppb.New(entry.ReceivedAt),
+			}); err != nil {
+				return err
+			}
+		case err := <-errChan:
+			return err
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// Delete implements workerpb.WorkerServiceServer. It removes a finished
+// process from the worker's registry.
+func (s *Server) Delete(ctx context.Context, req *workerpb.DeleteRequest) (*workerpb.DeleteResponse, error) {
+	if err := s.worker.DeleteProcess(worker.ID(req.GetId())); err != nil {
+		return nil, err
+	}
+	return &workerpb.DeleteResponse{}, nil
+}
+
+// requestedBy derives the caller identity from the mTLS client certificate
+// presented on ctx's peer connection. fallback is only used when the peer
+// has no verified certificate, which should not happen once the server
+// requires client certs but is kept so local/insecure testing still works.
+func requestedBy(ctx context.Context, fallback string) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.AuthInfo == nil {
+		return fallback
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.PeerCertificates) == 0 {
+		return fallback
+	}
+	return tlsInfo.State.PeerCertificates[0].Subject.CommonName
+}
+
+func serverTransportCreds(cfg TLSConfig) (credentials.TransportCredentials, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server cert/key: %w", err)
+	}
+
+	caPEM, err := os.ReadFile(cfg.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("failed to parse CA file %s", cfg.CAFile)
+	}
+
+	return credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    caPool,
+	}), nil
+}
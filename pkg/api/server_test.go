@@ -0,0 +1,265 @@
+package api_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/trivelle/worker/lib/worker"
+	"github.com/trivelle/worker/pkg/api"
+	"github.com/trivelle/worker/pkg/api/workerpb"
+)
+
+// testCA is a self-signed CA used to issue the server and client leaf
+// certificates these tests need for mTLS, so they don't depend on any
+// certificates checked into the repo.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Unix(0, 0),
+		NotAfter:              time.Unix(0, 0).Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) pem() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+}
+
+// issue signs a leaf certificate for commonName off of ca, returning its
+// certificate and private key PEM encodings.
+func (ca *testCA) issue(t *testing.T, commonName string, extKeyUsage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Unix(0, 0),
+		NotAfter:     time.Unix(0, 0).Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{extKeyUsage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM
+}
+
+// mtlsFixture holds the file paths of a CA plus a server and client leaf
+// certificate issued from it, ready to plug into api.TLSConfig, along
+// with the client certificate's raw tls.Certificate for dialing directly
+// against a bufconn listener (which api.Client.Dial cannot do).
+type mtlsFixture struct {
+	serverTLS  api.TLSConfig
+	caPool     *x509.CertPool
+	clientCert tls.Certificate
+	clientCN   string
+}
+
+func newMTLSFixture(t *testing.T) mtlsFixture {
+	t.Helper()
+
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	caFile := writeFile(t, dir, "ca.pem", ca.pem())
+
+	serverCert, serverKey := ca.issue(t, "test-server", x509.ExtKeyUsageServerAuth)
+	serverCertFile := writeFile(t, dir, "server.pem", serverCert)
+	serverKeyFile := writeFile(t, dir, "server-key.pem", serverKey)
+
+	const clientCN = "alice@example.com"
+	clientCertPEM, clientKeyPEM := ca.issue(t, clientCN, x509.ExtKeyUsageClientAuth)
+	clientCert, err := tls.X509KeyPair(clientCertPEM, clientKeyPEM)
+	require.NoError(t, err)
+
+	caPool := x509.NewCertPool()
+	caPool.AddCert(ca.cert)
+
+	return mtlsFixture{
+		serverTLS:  api.TLSConfig{CertFile: serverCertFile, KeyFile: serverKeyFile, CAFile: caFile},
+		caPool:     caPool,
+		clientCert: clientCert,
+		clientCN:   clientCN,
+	}
+}
+
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, data, 0600))
+	return path
+}
+
+// newTestServer starts a Server backed by a fresh worker.Worker over an
+// in-memory bufconn listener, and returns a WorkerServiceClient dialed
+// against it with fix's client certificate. Cleanup stops the server and
+// closes the connection and listener.
+func newTestServer(t *testing.T, fix mtlsFixture) workerpb.WorkerServiceClient {
+	t.Helper()
+
+	w := worker.NewWorker(worker.Config{})
+	s, err := api.NewServer(w, api.Config{TLS: fix.serverTLS})
+	require.NoError(t, err)
+
+	lis := bufconn.Listen(1024 * 1024)
+	go s.ServeListener(lis)
+	t.Cleanup(func() {
+		s.Stop()
+		lis.Close()
+	})
+
+	creds := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{fix.clientCert},
+		RootCAs:      fix.caPool,
+		ServerName:   "test-server",
+	})
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(creds),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return workerpb.NewWorkerServiceClient(conn)
+}
+
+func TestServerCreateAndGetProcessStatusRoundTrip(t *testing.T) {
+	client := newTestServer(t, newMTLSFixture(t))
+	ctx := context.Background()
+
+	createResp, err := client.CreateProcess(ctx, &workerpb.CreateProcessRequest{
+		Command: "sh",
+		Args:    []string{"-c", "exit 0"},
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, createResp.GetId())
+
+	status, err := client.GetProcessStatus(ctx, &workerpb.GetProcessStatusRequest{Id: createResp.GetId()})
+	require.NoError(t, err)
+	require.NotEmpty(t, status.GetState())
+}
+
+func TestServerStopProcessRoundTrip(t *testing.T) {
+	client := newTestServer(t, newMTLSFixture(t))
+	ctx := context.Background()
+
+	createResp, err := client.CreateProcess(ctx, &workerpb.CreateProcessRequest{
+		Command: "sleep",
+		Args:    []string{"10"},
+	})
+	require.NoError(t, err)
+
+	_, err = client.StopProcess(ctx, &workerpb.StopProcessRequest{Id: createResp.GetId()})
+	require.NoError(t, err)
+}
+
+func TestServerStreamProcessOutputRoundTrip(t *testing.T) {
+	client := newTestServer(t, newMTLSFixture(t))
+	ctx := context.Background()
+
+	createResp, err := client.CreateProcess(ctx, &workerpb.CreateProcessRequest{
+		Command: "echo",
+		Args:    []string{"hello"},
+	})
+	require.NoError(t, err)
+
+	stream, err := client.StreamProcessOutput(ctx, &workerpb.StreamProcessOutputRequest{Id: createResp.GetId()})
+	require.NoError(t, err)
+
+	var content string
+	for {
+		entry, err := stream.Recv()
+		if err != nil {
+			break
+		}
+		content += string(entry.GetContent())
+	}
+	require.Contains(t, content, "hello")
+}
+
+func TestServerDeleteRoundTrip(t *testing.T) {
+	client := newTestServer(t, newMTLSFixture(t))
+	ctx := context.Background()
+
+	createResp, err := client.CreateProcess(ctx, &workerpb.CreateProcessRequest{
+		Command: "sh",
+		Args:    []string{"-c", "exit 0"},
+	})
+	require.NoError(t, err)
+
+	_, err = client.GetProcessStatus(ctx, &workerpb.GetProcessStatusRequest{Id: createResp.GetId()})
+	require.NoError(t, err)
+
+	_, err = client.Delete(ctx, &workerpb.DeleteRequest{Id: createResp.GetId()})
+	require.NoError(t, err)
+
+	_, err = client.GetProcessStatus(ctx, &workerpb.GetProcessStatusRequest{Id: createResp.GetId()})
+	require.Error(t, err)
+}
+
+// TestServerRequestedByFromClientCertCommonName verifies that a caller's
+// identity is derived from its mTLS client certificate's CommonName, not
+// from the (unset) RequestedBy field on the request, matching worker.proto's
+// documented contract for CreateProcessRequest.requested_by.
+func TestServerRequestedByFromClientCertCommonName(t *testing.T) {
+	fix := newMTLSFixture(t)
+	client := newTestServer(t, fix)
+	ctx := context.Background()
+
+	createResp, err := client.CreateProcess(ctx, &workerpb.CreateProcessRequest{
+		Command: "sh",
+		Args:    []string{"-c", "exit 0"},
+	})
+	require.NoError(t, err)
+
+	status, err := client.GetProcessStatus(ctx, &workerpb.GetProcessStatusRequest{Id: createResp.GetId()})
+	require.NoError(t, err)
+	require.Equal(t, fix.clientCN, status.GetStartedBy())
+}